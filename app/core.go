@@ -4,6 +4,8 @@ package app
 import (
     "bufio"
     "dvr/log"
+    "dvr/message"
+    "dvr/metric"
 	"os"
 	"strconv"
 	"strings"
@@ -27,12 +29,13 @@ DVR: Distance Vector Routing Server
 --------------------------------------
 Available commands:
     1. help
-    2. update <server-ID1> <server-ID2> <new-link-cost>
+    2. update <server-ID1> <server-ID2> <new-link-cost | delay=ms bw=Mbps loss=percent>
     3. step
     4. packets
-    5. display
+    5. display [state]
     6. disable <server-id>
     7. crash
+    9. reload
 
 Type 'help' or 'help <command>' to get the explanation
 for the commands.
@@ -68,7 +71,6 @@ func (a *Application) WaitForInput() error {
             a.Log.OutError("%v\n", err)
         }
     }
-    return nil
 }
 
 // parseInput parses the users input and calls the function associated with
@@ -105,6 +107,10 @@ func (a *Application) parseInput(userInput string) error {
     case "5":
         fallthrough
     case a.Commands["5"]:
+        // Did they ask for the route states as well?
+        if numArgs == 2 && inputArgs[1] == "state" {
+            return a.displayState()
+        }
         return a.display()
     case "6":
         fallthrough
@@ -124,6 +130,10 @@ func (a *Application) parseInput(userInput string) error {
         a.Log.OutApp("Shutting down server .. \n")
         a.Server.Crash()
         return ExitErr
+    case "9":
+        fallthrough
+    case a.Commands["9"]:
+        return a.reload()
     default:
         // We didn't find a matching command for their input, let's throw an error
         return ErrInp
@@ -186,20 +196,34 @@ func (a *Application) update(inputArgs []string) error {
     command := strings.ToUpper(a.Commands["2"])
 
     // Let's get the first ID# given
-    id1, err := strconv.ParseInt(inputArgs[1], 10, 32)
+    id1, err := strconv.ParseUint(inputArgs[1], 10, 16)
     if err != nil {
         return errors.Wrapf(err, "%s ERROR: error parsing input id1: %v\n", command, err)
     }
 
     // Let's get the second ID# given
-    id2, err := strconv.ParseInt(inputArgs[2], 10, 32)
+    id2, err := strconv.ParseUint(inputArgs[2], 10, 16)
     if err != nil {
         return errors.Wrapf(err, "%s ERROR: error parsing input id2: %v\n", command, err)
     }
 
-    // Let's check if the link cost given was "inf"
+    // Were we given link attributes instead of a cost?
+    if metric.IsAttr(inputArgs[3]) {
+        m, err := metric.Parse(strings.Fields(inputArgs[3]))
+        if err != nil {
+            return errors.Wrapf(err, "%s ERROR: error parsing input link attributes: %v\n", command, err)
+        }
+        if err := a.Server.UpdateMetrics(uint16(id1), uint16(id2), m); err != nil {
+            return errors.Wrapf(err, "%s ERROR: %v\n", command, err)
+        }
+        a.Log.OutApp("\n%s SUCCESS\n", command)
+        return nil
+    }
+
+    // Let's check if the link cost given was "inf", the largest cost
+    // we can send is at least as large as the network's infinity
     if inputArgs[3] == "inf" {
-        inputArgs[3] = "99999"
+        inputArgs[3] = strconv.Itoa(message.MaxCost)
     }
 
     // Parse the link cost into an int
@@ -249,12 +273,27 @@ func (a *Application) display() error {
     return nil
 }
 
+// displayState calls the server to display the routing table with the
+// state of each route
+func (a *Application) displayState() error {
+    command := strings.ToUpper(a.Commands["5"])
+    // Call the servers route state function and check for any errors
+    if err := a.Server.DisplayState(); err != nil {
+        return errors.Wrapf(err, "%s ERROR: %v\n", command, err)
+    }
+    a.Log.OutApp("\n%s SUCCESS\n", command)
+    return nil
+}
+
 // disable calls the server to disable a server
 func (a *Application) disable(idString string) error {
     command := strings.ToUpper(a.Commands["6"])
     // Yes, so let's get the connection that we need to terminate
     // and attempt to disable it
-    id, _ := strconv.Atoi(idString)
+    id, err := strconv.ParseUint(idString, 10, 16)
+    if err != nil {
+        return errors.Wrapf(err, "%s ERROR: error parsing input id: %v\n", command, err)
+    }
 
     // Call the servers disable function and check for any errors
     if err := a.Server.Disable(uint16(id)); err != nil {
@@ -264,6 +303,17 @@ func (a *Application) disable(idString string) error {
     return nil
 }
 
+// reload calls the server to reload the topology file
+func (a *Application) reload() error {
+    command := strings.ToUpper(a.Commands["9"])
+    // Call the servers reload function and check for any errors
+    if err := a.Server.Reload(); err != nil {
+        return errors.Wrapf(err, "%s ERROR: %v\n", command, err)
+    }
+    a.Log.OutApp("\n%s SUCCESS\n", command)
+    return nil
+}
+
 // crash calls the server to crash it
 func (a *Application) crash() error {
     command := strings.ToUpper(a.Commands["7"])
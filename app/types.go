@@ -2,7 +2,6 @@ package app
 
 import (
     "dvr/log"
-    "dvr/network"
     "dvr/server"
 )
 
@@ -10,6 +9,5 @@ import (
 type Application struct {
     Commands map[string]string
     Log *log.Logger
-    Network *network.Network
     Server *server.Server
 }
@@ -5,7 +5,7 @@ import "errors"
 // Create some common errors for input mistakes we may see
 
 // ErrUpd is an error message for our update command
-var ErrUpd error = errors.New("update ERROR: You must provide 2 server ID#s and the new link cost, which can be either a number or 'inf'")
+var ErrUpd error = errors.New("update ERROR: You must provide 2 server ID#s and the new link cost, which can be either a number or 'inf', or link attributes like delay=20 bw=100 loss=1")
 
 // ErrDis is an error message for our disable command
 var ErrDis error = errors.New("disable ERROR: You must give the server id you wish to disable\nType `display` to view the current routing table")
@@ -28,16 +28,19 @@ var commands = map[string]string{
 	"6": "disable",
 	"7": "crash",
 	"8": "exit",
+	"9": "reload",
 }
 
 // The helpText to display for each command
 var helpText = map[string]string{
 	"help": "1. help - Displays available application commands\n",
-	"update": "2. update <server-ID1> <server-ID2> <new-link-cost> - Updates the link cost between the two servers\n",
+	"update": "2. update <server-ID1> <server-ID2> <new-link-cost> - Updates the link cost between the two servers\n" +
+		"   update <server-ID1> <server-ID2> delay=<ms> bw=<Mbps> loss=<percent> - Updates some of the link's attributes instead, and the link cost becomes their composite cost\n",
 	"step": "3. step - Triggers the server to send the routing update right away\n",
 	"packets": "4. packets - Displays the number of DVR packets this server has received since the last time this command was used\n",
-	"display": "5. display - Displays the current routing table, with the servers sorted in ascending order\n",
+	"display": "5. display [state] - Displays the current routing table, with the servers sorted in ascending order. With 'state', every route is shown along with its state (valid, expired or deleted) and how long until it changes\n",
 	"disable": "6. disable <server-ID> - Disables the link between to a given server\n",
 	"crash": "7. crash - 'Closes' all connections, to simulate a server crash\n",
 	"exit": "8. exit - Exits the aplication.",
+	"reload": "9. reload - Reads the topology file again and applies the link changes in it, the same as sending SIGHUP\n",
 }
@@ -2,18 +2,41 @@ package main
 
 import (
     "dvr/app"
+    "dvr/dv"
+    "dvr/metric"
     "dvr/network"
     "dvr/topology"
     "errors"
     "flag"
     "fmt"
+    "math"
     "os"
+    "strings"
     "time"
 )
 
 var file string
+var id int
 var interval int
 var debug bool
+var splitHorizon string
+var infinity int
+var triggered bool
+var holdDown int
+var routeTimeout int
+var garbageTimeout int
+var engine string
+var maxAge int
+var weights string
+var probeInterval int
+var probeUnit int
+var helloInterval int
+var deadMultiplier int
+var discovery bool
+var discoveryCost int
+var peers string
+var legacy bool
+var checksum bool
 
 // usage prints information on how to use the program and then exits
 func usage() {
@@ -25,13 +48,32 @@ func usage() {
 // checkFlags checks the command line flags given at startup
 func checkFlags() {
     // Lets load our flags.
-    flag.StringVar(&file, "t", "", "Topology file name.")
-    flag.IntVar(&interval, "i", -1, "Routing update interval, in seconds.")
+    flag.StringVar(&file, "t", "", "Topology file name, a .json file or the text format.")
+    flag.IntVar(&id, "id", 0, "Our server's ID, for a topology file shared by the whole network (default: the file's self).")
+    flag.IntVar(&interval, "i", -1, "Routing update interval, in seconds (default: the topology file's).")
+    flag.StringVar(&engine, "e", "dv", "Routing engine: 'dv' (distance vector), 'pv' (path vector), 'dsdv' (destination-sequenced distance vector), 'babel' (distance vector with feasibility conditions) or 'ls' (link state).")
     flag.BoolVar(&debug, "d", false, "Whether or not to show routing tables for debugging.")
+    flag.IntVar(&infinity, "m", 0, "Maximum metric, the cost at which a server is unreachable (default: the topology file's, or 16, or 1024 when probing).")
+    flag.BoolVar(&triggered, "u", false, "Whether to send triggered updates as soon as a route changes.")
+    flag.IntVar(&holdDown, "hd", 0, "Hold-down period for unreachable routes, in seconds (0 turns hold-down off).")
+    flag.IntVar(&routeTimeout, "rt", 0, "Route timeout, in seconds (default: 6 update intervals).")
+    flag.IntVar(&garbageTimeout, "gc", 0, "Garbage collection timeout for expired routes, in seconds (default: 4 update intervals).")
+    flag.IntVar(&maxAge, "ma", 0, "Link state advertisement max age, in seconds (default: 6 update intervals).")
+    flag.StringVar(&weights, "w", "", "Composite cost weights for link attributes, like delay=1,bw=1,loss=1,ref=1000 (default: all 1, reference bandwidth 1000 Mbps).")
+    flag.IntVar(&probeInterval, "p", 0, "Link probe interval, in seconds, to set link costs from measured round trip time and loss (0 turns probing off).")
+    flag.IntVar(&probeUnit, "pu", 1000, "Round trip time, in microseconds, that a probed link's cost counts as 1.")
+    flag.IntVar(&helloInterval, "hi", 0, "Hello interval, in milliseconds, for detecting dead neighbors faster than the routing updates do (0 turns hellos off).")
+    flag.IntVar(&deadMultiplier, "dm", 3, "Dead multiplier, how many hellos can be missed before a neighbor is taken for dead.")
+    flag.BoolVar(&discovery, "dn", false, "Whether to discover neighbors from their hellos, instead of only using the topology file's.")
+    flag.IntVar(&discoveryCost, "dc", 1, "Link cost given to discovered neighbors.")
+    flag.StringVar(&peers, "dp", "", "Comma separated addresses, like 192.168.0.104:2001 or [::1]:2001, to send hellos to so the servers there discover us. Broadcast addresses work too.")
+    flag.BoolVar(&legacy, "legacy", false, "Whether to send messages in the assignment's format, without a header, to talk to servers that only speak that.")
+    flag.BoolVar(&checksum, "cs", false, "Whether to end the messages we send with a CRC32C checksum, so corrupted ones get dropped. Messages with checksums are always checked.")
+    flag.StringVar(&splitHorizon, "s", "poison", "Split horizon mode: 'off', 'simple' or 'poison' (poisoned reverse).")
     flag.Parse()
 
-    // Did we get a file name or interval to update?
-    if interval == -1 || file == "" {
+    // Did we get a file name?
+    if file == "" {
         usage()
     }
 
@@ -45,22 +87,124 @@ func checkFlags() {
 func main() {
     checkFlags()
 
-    a := app.New()
-    a.Log.Debug = debug
-    a.StartupText()
+    if id < 0 || id > math.MaxUint16 {
+        fmt.Printf("Invalid -id flag - server ID must be between 1 and %d\n", math.MaxUint16)
+        os.Exit(-1)
+    }
+    top, serverID, err := topology.ParseTopology(file, uint16(id))
+    if err != nil {
+        fmt.Printf("Failed to parse topology file - %s\n", err.Error())
+        os.Exit(-1)
+    }
 
-    //a.Log.OutDebug("Parsing topology file .. \n")
+    // The topology file may give the update interval instead of -i
+    if interval == -1 {
+        interval = top.Interval
+    }
+    if interval < 1 {
+        usage()
+    }
 
-    top, serverID, err := topology.ParseTopology(file)
+    var cfg network.Config
+    cfg.TopologyFile = file
+    e, err := network.ParseEngine(engine)
     if err != nil {
-        fmt.Printf("Failed to parse topology file - %s\n", err.Error())
+        fmt.Printf("Invalid -e flag - %s\n", err.Error())
+        os.Exit(-1)
+    }
+    cfg.Engine = e
+
+    cfg.Weights = metric.DefaultWeights
+    if weights != "" {
+        w, err := metric.ParseWeights(weights)
+        if err != nil {
+            fmt.Printf("Invalid -w flag - %s\n", err.Error())
+            os.Exit(-1)
+        }
+        cfg.Weights = w
+    }
+
+    sh, err := dv.ParseSplitHorizon(splitHorizon)
+    if err != nil {
+        fmt.Printf("Invalid -s flag - %s\n", err.Error())
+        os.Exit(-1)
+    }
+    cfg.DV.SplitHorizon = sh
+
+    if infinity != 0 {
+        if err := network.CheckInfinity(infinity); err != nil {
+            fmt.Printf("Invalid -m flag - %s\n", err.Error())
+            os.Exit(-1)
+        }
+        cfg.Infinity = infinity
+    }
+
+    cfg.Triggered = triggered
+    cfg.DV.HoldDown = time.Duration(holdDown) * time.Second
+
+    // Like RIP's 180 and 120 second timers with its 30 second interval
+    if routeTimeout == 0 {
+        routeTimeout = 6 * interval
+    }
+    if garbageTimeout == 0 {
+        garbageTimeout = 4 * interval
+    }
+    cfg.DV.RouteTimeout = time.Duration(routeTimeout) * time.Second
+    cfg.DV.GarbageTimeout = time.Duration(garbageTimeout) * time.Second
+
+    if maxAge == 0 {
+        maxAge = 6 * interval
+    }
+    cfg.LS.MaxAge = time.Duration(maxAge) * time.Second
+    cfg.ProbeInterval = time.Duration(probeInterval) * time.Second
+
+    if probeUnit < 1 {
+        fmt.Printf("Invalid -pu flag - probe unit must be at least 1 microsecond\n")
+        os.Exit(-1)
+    }
+    cfg.ProbeUnit = time.Duration(probeUnit) * time.Microsecond
+
+    if deadMultiplier < 1 || deadMultiplier > math.MaxUint16 {
+        fmt.Printf("Invalid -dm flag - dead multiplier must be between 1 and %d\n", math.MaxUint16)
         os.Exit(-1)
     }
+    cfg.HelloInterval = time.Duration(helloInterval) * time.Millisecond
+    cfg.DeadMultiplier = deadMultiplier
+
+    if discovery {
+        if discoveryCost < 1 {
+            fmt.Printf("Invalid -dc flag - discovered link cost must be at least 1\n")
+            os.Exit(-1)
+        }
+        cfg.Discovery = true
+        cfg.DiscoveryCost = discoveryCost
+        if peers != "" {
+            cfg.Peers = strings.Split(peers, ",")
+        }
+
+        // Discovery needs hellos to be going out
+        if cfg.HelloInterval == 0 {
+            cfg.HelloInterval = time.Second
+        }
+    }
+
+    cfg.Legacy = legacy
+    cfg.Checksum = checksum
+    if err := network.CheckLegacy(top, cfg); err != nil {
+        fmt.Printf("Invalid -legacy flag - %s\n", err.Error())
+        os.Exit(-1)
+    }
+
+    a := app.New()
+    a.Log.Debug = debug
+    a.StartupText()
+
     //a.Log.OutDebug("Successfully parsed topology file.\nStarting network setup now ..\n")
-    a.Server = network.New(top, serverID, a.Log)
+    a.Server = network.New(top, serverID, cfg, a.Log)
 
     go a.Server.Listen()
     go a.Server.Loopy(interval)
+    go reloadOnSignal(a)
 
     // Print the current topology setup
     a.Log.OutServer("\nTOPOLOGY\n")
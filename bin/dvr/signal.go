@@ -0,0 +1,28 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+    "dvr/app"
+    "os"
+    "os/signal"
+    "syscall"
+)
+
+// reloadOnSignal reloads the topology file whenever we get a SIGHUP, like
+// the reload command does
+func reloadOnSignal(a *app.Application) {
+    hup := make(chan os.Signal, 1)
+    signal.Notify(hup, syscall.SIGHUP)
+
+    for range hup {
+        a.Log.OutServer("\nRECEIVED SIGHUP, RELOADING TOPOLOGY\n")
+        if err := a.Server.Reload(); err != nil {
+            a.Log.OutError("\nRELOAD ERROR: %v\n", err)
+        } else {
+            a.Log.OutApp("\nRELOAD SUCCESS\n")
+        }
+        a.Log.OutApp("\nPlease enter a command: ")
+    }
+}
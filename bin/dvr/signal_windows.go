@@ -0,0 +1,7 @@
+package main
+
+import "dvr/app"
+
+// reloadOnSignal does nothing, there's no SIGHUP on windows. The reload
+// command still works.
+func reloadOnSignal(a *app.Application) {}
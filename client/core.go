@@ -11,6 +11,11 @@ import (
 // Client struct for holding the client connection
 type Client struct {
 	Conn net.Conn
+
+	// The connection to send from instead, and the address to send to,
+	// for clients sharing a connection like our listener
+	pc   net.PacketConn
+	addr net.Addr
 }
 
 // NewClient creates and returns a new client using the provided bind address
@@ -36,13 +41,28 @@ func NewClient(address string) (*Client, error) {
 	return &c, nil
 }
 
+// NewClientFrom creates and returns a new client that sends to the provided
+// address from an existing connection, so the packets come from its address
+func NewClientFrom(pc net.PacketConn, address string) (*Client, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return &Client{}, errors.Wrapf(err, "NewClientFrom(%s): failed to resolve address", address)
+	}
+	return &Client{pc: pc, addr: addr}, nil
+}
+
 // SendPacket sends the provided packet to the client connection and then closes the connection
 func (c *Client) SendPacket(packet []byte, log *log.Logger) {
 	// Defer closing our client connection
 	defer c.close()
 
-	// Write the packet to the connection
-	_, err := c.Conn.Write(packet)
+	// Write the packet to the connection, or from the one we share
+	var err error
+	if c.pc != nil {
+		_, err = c.pc.WriteTo(packet, c.addr)
+	} else {
+		_, err = c.Conn.Write(packet)
+	}
 	if err != nil {
 		// Some error occurred ..
 		// Create a new error message, print it to the user and return
@@ -0,0 +1,123 @@
+package dv
+
+import (
+    "dvr/message"
+    "time"
+)
+
+// Route requests are forwarded towards the destination at most requestHops
+// times, and we ask for a route to the same destination at most once every
+// requestInterval
+const (
+    requestHops = 16
+    requestInterval = time.Second
+)
+
+// distance is a feasibility distance, the best route to a destination we've
+// advertised for its newest sequence number
+type distance struct {
+    seq uint32
+    cost int
+}
+
+// bidirectional returns the links to the neighbors that have heard us
+func (e *Engine) bidirectional() map[uint16]int {
+    links := make(map[uint16]int, len(e.links))
+    for id, cost := range e.links {
+        if e.ihu[id] {
+            links[id] = cost
+        }
+    }
+    return links
+}
+
+// feasible checks a route a neighbor advertised against the feasibility
+// distance of its destination. A route with a newer sequence number, or
+// the same one and a lower cost than we've advertised, can't go through
+// us, so it can't form a loop.
+func (e *Engine) feasible(dest uint16, a advert) bool {
+    fd, ok := e.fd[dest]
+    if !ok || !e.reachable(a.cost) {
+        return true
+    }
+    return a.seq > fd.seq || (a.seq == fd.seq && a.cost < fd.cost)
+}
+
+// updateFD lowers the feasibility distance of a destination to the route
+// we're about to advertise for it, if that's better
+func (e *Engine) updateFD(dest uint16, seq uint32, cost int) {
+    fd, ok := e.fd[dest]
+    if !ok || seq > fd.seq || (seq == fd.seq && cost < fd.cost) {
+        e.fd[dest] = distance{seq: seq, cost: cost}
+    }
+}
+
+// checkFeasible asks for a newer route to a destination we've lost, if a
+// neighbor still has a route to it we can't use because it's unfeasible
+func (e *Engine) checkFeasible(dest uint16, now time.Time) {
+    if now.Sub(e.requested[dest]) < requestInterval {
+        return
+    }
+
+    unfeasible := false
+    for id := range e.bidirectional() {
+        a, ok := e.ribIn[id][dest]
+        if ok && e.reachable(a.cost) && !e.feasible(dest, a) {
+            unfeasible = true
+        }
+    }
+    if !unfeasible {
+        return
+    }
+    e.requested[dest] = now
+
+    // Only the destination can make the route feasible again, by moving
+    // its sequence number past our feasibility distance
+    req := message.Request{ID: dest, Seq: e.fd[dest].seq + 1, Hops: requestHops}
+    e.log.OutDebug("\ne.checkFeasible: requesting a route to server %d with sequence number %d\n", dest, req.Seq)
+    for id := range e.bidirectional() {
+        e.requests[id] = append(e.requests[id], req)
+    }
+}
+
+// Wanted returns the destinations we were asked for a route to that we
+// already have, so that a triggered update carries them
+func (e *Engine) Wanted() []uint16 {
+    ids := make([]uint16, 0, len(e.wanted))
+    for id := range e.wanted {
+        ids = append(ids, id)
+        delete(e.wanted, id)
+    }
+    return ids
+}
+
+// handleRequest takes in a route request from a neighbor, and reports
+// whether we have an update or request to send because of it
+func (e *Engine) handleRequest(from uint16, req message.Request, now time.Time) bool {
+    // It's for us, so our next update needs the newer sequence number
+    if req.ID == e.id {
+        e.log.OutDebug("\ne.handleRequest: server %d requested our sequence number %d\n", from, req.Seq)
+        if req.Seq > e.seq {
+            e.seq = req.Seq
+        }
+        return true
+    }
+
+    // We already have a new enough route, it just needs to be sent
+    rt, ok := e.routes[req.ID]
+    if !ok || !e.reachable(rt.cost) {
+        return false
+    }
+    if rt.seq >= req.Seq {
+        e.wanted[req.ID] = true
+        return true
+    }
+
+    // Otherwise we pass the request on towards the destination
+    if req.Hops <= 1 || rt.nextHop == from {
+        return false
+    }
+    req.Hops--
+    e.requests[rt.nextHop] = append(e.requests[rt.nextHop], req)
+    return true
+}
@@ -0,0 +1,41 @@
+package dv
+
+import (
+    "dvr/log"
+    "dvr/message"
+    "testing"
+    "time"
+)
+
+func TestRequestWanted(t *testing.T) {
+    // Asked for a route we already have that's new enough, we send it in
+    // the next update instead of passing the request on
+    e := New(1, Config{Infinity: 16, Feasibility: true}, log.New())
+    e.SetLinkCost(2, 1)
+    e.HandleUpdate(2, &message.Message{N: []message.Neighbor{{ID: 1, Cost: 1}, {ID: 2}, {ID: 3, Cost: 2, Seq: 4}}})
+    if rt := e.routes[3]; rt.cost != 3 || rt.seq != 4 {
+        t.Fatalf("route to 3 = %+v, want cost 3 with sequence number 4", rt)
+    }
+
+    if !e.handleRequest(4, message.Request{ID: 3, Seq: 4, Hops: 5}, time.Now()) {
+        t.Errorf("request for a route we have didn't ask for an update")
+    }
+    if wanted := e.Wanted(); len(wanted) != 1 || wanted[0] != 3 {
+        t.Errorf("wanted %v, want [3]", wanted)
+    }
+    if wanted := e.Wanted(); len(wanted) != 0 {
+        t.Errorf("wanted %v a second time, want nothing", wanted)
+    }
+    if len(e.requests[2]) != 0 {
+        t.Errorf("request was passed on to 2: %+v", e.requests[2])
+    }
+
+    // A request for a newer route than ours goes on towards the destination
+    e.handleRequest(4, message.Request{ID: 3, Seq: 6, Hops: 5}, time.Now())
+    if len(e.requests[2]) != 1 || e.requests[2][0].Hops != 4 {
+        t.Errorf("requests for 2 = %+v, want the request with 4 hops left", e.requests[2])
+    }
+    if wanted := e.Wanted(); len(wanted) != 0 {
+        t.Errorf("wanted %v for a route that's too old, want nothing", wanted)
+    }
+}
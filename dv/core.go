@@ -0,0 +1,259 @@
+// Package dv provides a distance vector routing engine
+package dv
+
+import (
+    "dvr/log"
+    "dvr/message"
+    "dvr/types"
+    "sort"
+    "time"
+)
+
+// New initializes and returns a new distance vector engine for the server
+// with the given ID.
+func New(id uint16, cfg Config, l *log.Logger) *Engine {
+    e := Engine{
+        id: id,
+        cfg: cfg,
+        links: make(map[uint16]int),
+        ribIn: make(ribIn),
+        routes: make(map[uint16]*route),
+        fd: make(map[uint16]distance),
+        heard: make(map[uint16]bool),
+        ihu: make(map[uint16]bool),
+        requests: make(map[uint16][]message.Request),
+        requested: make(map[uint16]time.Time),
+        wanted: make(map[uint16]bool),
+        log: l,
+    }
+    return &e
+}
+
+// ParseSplitHorizon returns the split horizon mode with the given name
+func ParseSplitHorizon(mode string) (SplitHorizon, error) {
+    switch mode {
+    case "off":
+        return SplitHorizonOff, nil
+    case "simple":
+        return SplitHorizonSimple, nil
+    case "poison":
+        return SplitHorizonPoison, nil
+    }
+    return SplitHorizonOff, SplitErr
+}
+
+// HandleUpdate stores a neighbor's advertisement in the Adj-RIB-In and
+// recomputes our routes from it
+func (e *Engine) HandleUpdate(from uint16, msg *message.Message) bool {
+    // We only listen to neighbors we have a link to
+    if _, ok := e.links[from]; !ok || from == e.id {
+        return false
+    }
+    now := time.Now()
+
+    // In feasibility mode every update is a hello, and the entry it has for
+    // us says whether the neighbor has heard us
+    if e.cfg.Feasibility {
+        e.heard[from] = true
+        n, ok := msg.Entry(e.id)
+        e.ihu[from] = ok && e.reachable(int(n.Cost))
+    }
+
+    entries := make(map[uint16]advert, len(msg.N))
+    for _, n := range msg.N {
+        a := advert{cost: e.metric(int(n.Cost)), seq: n.Seq}
+
+        // Someone has a newer entry for us than our own, from before we
+        // restarted, so we carry on from the next even sequence number
+        if e.cfg.Sequenced && n.ID == e.id && n.Seq >= e.seq {
+            e.seq = (n.Seq | 1) + 1
+        }
+
+        // A route that already goes through us would loop, so we treat
+        // it as unreachable. The path is the decoder's, so we keep a copy.
+        if e.cfg.PathVector {
+            a.path = append([]uint16(nil), n.Path...)
+            if len(a.path) == 0 || a.path[0] != from || hasID(a.path, e.id) {
+                a.cost = e.cfg.Infinity
+            }
+        }
+        entries[n.ID] = a
+    }
+
+    // Hearing from a neighbor keeps our direct route to it alive, and the
+    // neighbor still advertising a route keeps our route through it alive
+    if rt, ok := e.routes[from]; ok && rt.nextHop == from {
+        e.refreshRoute(rt, now)
+    }
+    for dest, a := range entries {
+        rt, ok := e.routes[dest]
+        if ok && rt.nextHop == from && rt.state == routeValid && e.reachable(a.cost) {
+            e.refreshRoute(rt, now)
+        }
+    }
+
+    e.ribIn.store(from, entries, !msg.Partial)
+    changed := e.recompute(now)
+
+    for _, req := range msg.Requests {
+        if e.handleRequest(from, req, now) {
+            changed = true
+        }
+    }
+    return changed
+}
+
+// SetLinkCost sets the cost of our direct link to a neighbor. When the link
+// goes down we forget everything the neighbor told us.
+func (e *Engine) SetLinkCost(id uint16, cost int) bool {
+    if id == e.id {
+        return false
+    }
+    e.route(id)
+
+    cost = e.metric(cost)
+    if e.reachable(cost) {
+        e.links[id] = cost
+    } else {
+        delete(e.links, id)
+        delete(e.ribIn, id)
+        delete(e.heard, id)
+        delete(e.ihu, id)
+        delete(e.requests, id)
+    }
+    return e.recompute(time.Now())
+}
+
+// Tick runs the route timers
+func (e *Engine) Tick(now time.Time) bool {
+    return e.checkRouteTimers(now)
+}
+
+// Periodic moves our own sequence number on in sequenced mode, so that
+// each periodic update is newer than anything we've said before
+func (e *Engine) Periodic() {
+    if e.cfg.Sequenced {
+        e.seq += 2
+    }
+}
+
+// Forget drops our route to a destination that has left the network, and
+// what our neighbors last told us about it. Neighbors we have a link to are
+// never forgotten.
+func (e *Engine) Forget(id uint16) {
+    if _, ok := e.links[id]; ok || id == e.id {
+        return
+    }
+    delete(e.routes, id)
+    delete(e.fd, id)
+    delete(e.requested, id)
+    delete(e.wanted, id)
+    for _, vector := range e.ribIn {
+        delete(vector, id)
+    }
+}
+
+// Advertisement returns our distance vector for the given neighbor, with
+// split horizon applied to the routes we learned from it
+func (e *Engine) Advertisement(to uint16) *message.Message {
+    msg := &message.Message{
+        N: make([]message.Neighbor, 0, len(e.routes) + 1),
+    }
+
+    // The packet needs to include an entry to reach ourself with cost 0
+    msg.Set(message.Neighbor{ID: e.id, Cost: 0, Path: e.path(nil), Seq: e.seq})
+
+    for id, rt := range e.routes {
+        // Deleted routes are no longer advertised at all
+        if rt.state == routeDeleted && id != to {
+            continue
+        }
+
+        // Was this route learned from the neighbor we're sending to? The
+        // neighbor's own entry is left alone, it never routes to itself
+        // through us.
+        cost := rt.cost
+        if hasID(rt.hops, to) && id != to {
+            if e.cfg.SplitHorizon == SplitHorizonSimple {
+                continue
+            }
+            if e.cfg.SplitHorizon == SplitHorizonPoison {
+                cost = e.cfg.Infinity
+            }
+        }
+        msg.Set(message.Neighbor{ID: id, Cost: uint16(e.metric(cost)), Path: e.path(rt.path), Seq: rt.seq})
+    }
+
+    // In feasibility mode the neighbor's entry tells it whether we've
+    // heard it, and carries the route requests waiting for it
+    if e.cfg.Feasibility {
+        cost := e.cfg.Infinity
+        if e.heard[to] {
+            cost = e.links[to]
+        }
+        msg.Set(message.Neighbor{ID: to, Cost: uint16(e.metric(cost)), Seq: e.route(to).seq})
+        msg.Requests = e.requests[to]
+        delete(e.requests, to)
+    }
+
+    msg.Updates = uint16(len(msg.N))
+    return msg
+}
+
+// Table returns our routes, sorted by destination ID
+func (e *Engine) Table() []types.Route {
+    now := time.Now()
+    table := make([]types.Route, 0, len(e.routes))
+    for id, rt := range e.routes {
+        table = append(table, types.Route{
+            ID: id,
+            NextHop: rt.nextHop,
+            NextHops: rt.hops,
+            Cost: rt.cost,
+            State: rt.state.String(),
+            Timer: e.routeTimer(id, rt, now),
+            Path: rt.path,
+            Seq: rt.seq,
+        })
+    }
+    sort.Slice(table, func(i, j int) bool {
+        return table[i].ID < table[j].ID
+    })
+    return table
+}
+
+// path returns the path we advertise for a route, which starts with us. It's
+// nil when we're not in path vector mode.
+func (e *Engine) path(rt []uint16) []uint16 {
+    if !e.cfg.PathVector {
+        return nil
+    }
+    path := make([]uint16, 0, len(rt) + 1)
+    path = append(path, e.id)
+    return append(path, rt...)
+}
+
+// route returns our route to a destination, adding an unreachable one if
+// we don't have one yet
+func (e *Engine) route(id uint16) *route {
+    rt, ok := e.routes[id]
+    if !ok {
+        rt = &route{nextHop: 0, cost: e.cfg.Infinity, state: routeDeleted}
+        e.routes[id] = rt
+    }
+    return rt
+}
+
+// metric caps a cost at our infinity, anything at or above it
+// is unreachable
+func (e *Engine) metric(cost int) int {
+    if cost >= e.cfg.Infinity {
+        return e.cfg.Infinity
+    }
+    return cost
+}
+
+// reachable checks if a cost is below our infinity
+func (e *Engine) reachable(cost int) bool {
+    return cost < e.cfg.Infinity
+}
@@ -0,0 +1,89 @@
+package dv
+
+import (
+    "dvr/log"
+    "dvr/message"
+    "testing"
+)
+
+// exchange has each engine send a full update to the engines it has a link
+// to, until none of their routes change, and reports whether they settled
+func exchange(engines ...*Engine) bool {
+    for i := 0; i < 20; i++ {
+        changed := false
+        for _, from := range engines {
+            for _, to := range engines {
+                if _, ok := from.links[to.id]; !ok || from == to {
+                    continue
+                }
+                if to.HandleUpdate(from.id, from.Advertisement(to.id)) {
+                    changed = true
+                }
+            }
+        }
+        if !changed {
+            return true
+        }
+    }
+    return false
+}
+
+// link sets the cost of the link between two engines on both ends
+func link(a, b *Engine, cost int) {
+    a.SetLinkCost(b.id, cost)
+    b.SetLinkCost(a.id, cost)
+}
+
+func TestWithdrawByOmission(t *testing.T) {
+    // A reaches C through B, so with split horizon A stops advertising C
+    // to B. Once the B-C link breaks, B mustn't fall back on the route to
+    // C that A advertised before, or A and B would route C to each other.
+    cfg := Config{Infinity: 16, SplitHorizon: SplitHorizonSimple}
+    a, b, c := New(1, cfg, log.New()), New(2, cfg, log.New()), New(3, cfg, log.New())
+    link(a, b, 1)
+    link(b, c, 1)
+    link(a, c, 10)
+    if !exchange(a, b, c) {
+        t.Fatalf("routes didn't settle")
+    }
+    if rt := a.routes[3]; rt.nextHop != 2 || rt.cost != 2 {
+        t.Fatalf("A's route to C = %+v, want cost 2 via B", rt)
+    }
+
+    link(b, c, 16)
+    if !exchange(a, b, c) {
+        t.Fatalf("routes didn't settle after the B-C link broke")
+    }
+    if rt := a.routes[3]; rt.nextHop != 3 || rt.cost != 10 {
+        t.Errorf("A's route to C = %+v, want cost 10 via C", rt)
+    }
+    if rt := b.routes[3]; rt.nextHop != 1 || rt.cost != 11 {
+        t.Errorf("B's route to C = %+v, want cost 11 via A", rt)
+    }
+}
+
+func TestPartialUpdateMerges(t *testing.T) {
+    // A triggered update only carries what changed, the rest of what the
+    // neighbor advertised before still stands
+    cfg := Config{Infinity: 16}
+    e := New(1, cfg, log.New())
+    e.SetLinkCost(2, 1)
+
+    full := &message.Message{N: []message.Neighbor{{ID: 2}, {ID: 3, Cost: 2}, {ID: 4, Cost: 3}}}
+    e.HandleUpdate(2, full)
+    partial := &message.Message{N: []message.Neighbor{{ID: 1, Cost: 1}, {ID: 2}, {ID: 4, Cost: 5}}, Partial: true}
+    e.HandleUpdate(2, partial)
+
+    if rt := e.routes[3]; rt.cost != 3 {
+        t.Errorf("route to 3 = %+v, want cost 3", rt)
+    }
+    if rt := e.routes[4]; rt.cost != 6 {
+        t.Errorf("route to 4 = %+v, want cost 6", rt)
+    }
+
+    // and a full update withdraws what it leaves out
+    e.HandleUpdate(2, &message.Message{N: []message.Neighbor{{ID: 1, Cost: 1}, {ID: 2}, {ID: 4, Cost: 5}}})
+    if rt := e.routes[3]; e.reachable(rt.cost) {
+        t.Errorf("route to 3 = %+v, want it unreachable", rt)
+    }
+}
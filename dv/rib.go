@@ -0,0 +1,252 @@
+package dv
+
+import (
+    "sort"
+    "time"
+)
+
+// ribIn is the Adj-RIB-In, the distance vector each neighbor last
+// advertised to us, keyed by neighbor ID and then destination ID
+type ribIn map[uint16]map[uint16]advert
+
+// advert is a route a neighbor advertised to us
+type advert struct {
+    cost int
+
+    // The servers the route goes through, starting with the neighbor, in
+    // path vector mode
+    path []uint16
+
+    // The sequence number the destination stamped the route with, in
+    // sequenced mode
+    seq uint32
+}
+
+// choice is the best way we know of to reach a destination
+type choice struct {
+    nextHop uint16
+    cost int
+    path []uint16
+    seq uint32
+
+    // Every next hop with the same cost, sorted by ID
+    hops []uint16
+}
+
+// better checks if a choice is better than another. In sequenced mode a
+// newer sequence number always wins, then the lower cost, then the lower
+// next hop ID.
+func (c choice) better(than choice, sequenced bool) bool {
+    if sequenced && c.seq != than.seq {
+        return c.seq > than.seq
+    }
+    if c.cost != than.cost {
+        return c.cost < than.cost
+    }
+    return c.nextHop < than.nextHop
+}
+
+// equal checks if two choices are as good as each other, apart from their
+// next hops
+func (c choice) equal(to choice, sequenced bool) bool {
+    return c.cost == to.cost && (!sequenced || c.seq == to.seq)
+}
+
+// store saves the entries of a neighbor's advertisement. A full update
+// replaces what the neighbor advertised before, so the routes it leaves out
+// are withdrawn. A partial one, a triggered update that only carries the
+// routes that changed, is merged into it.
+func (rib ribIn) store(from uint16, entries map[uint16]advert, full bool) {
+    vector, ok := rib[from]
+    if !ok || full {
+        vector = make(map[uint16]advert, len(entries))
+        rib[from] = vector
+    }
+    for dest, a := range entries {
+        vector[dest] = a
+    }
+}
+
+// bestRoutes works out the best route to each destination from the cost of
+// our links to our neighbors and the vectors they last advertised, using the
+// bellman-ford equation. Only the neighbors in links are used, so a neighbor
+// whose link is down should be left out. Ties go to the lowest next hop ID,
+// so the same inputs always give the same routes. Destinations we can't reach
+// are left out. In path vector mode each route keeps the path it was
+// advertised with, our direct routes have a path of just the neighbor.
+//
+// In sequenced mode the newest route wins even if it's unreachable, since
+// that means the link to the destination broke. Those are left in, with
+// the cost capped at inf. If feasible isn't nil, only the routes it
+// accepts are used. Every next hop with the best cost is kept, but the
+// path is the one through the lowest next hop ID.
+func bestRoutes(self uint16, inf int, cfg Config, links map[uint16]int, rib ribIn, feasible func(uint16, advert) bool) map[uint16]choice {
+    routes := make(map[uint16]choice, len(links))
+
+    consider := func(dest uint16, c choice) {
+        if dest == self {
+            return
+        }
+        if c.cost >= inf {
+            if !cfg.Sequenced {
+                return
+            }
+            c = choice{nextHop: 0, cost: inf, seq: c.seq}
+        } else {
+            c.hops = []uint16{c.nextHop}
+        }
+        best, ok := routes[dest]
+        if ok && c.cost < inf && c.equal(best, cfg.Sequenced) {
+            c.hops = addID(best.hops, c.nextHop)
+            if !c.better(best, cfg.Sequenced) {
+                best.hops = c.hops
+                routes[dest] = best
+                return
+            }
+        }
+        if !ok || c.better(best, cfg.Sequenced) {
+            routes[dest] = c
+        }
+    }
+
+    for id, linkCost := range links {
+        // Our direct link to the neighbor, which is as new as the last
+        // entry the neighbor stamped for itself
+        c := choice{nextHop: id, cost: linkCost, seq: rib[id][id].seq}
+        if cfg.PathVector {
+            c.path = []uint16{id}
+        }
+        if feasible == nil || feasible(id, rib[id][id]) {
+            consider(id, c)
+        }
+
+        // and everything the neighbor can reach
+        for dest, a := range rib[id] {
+            if dest == id || (feasible != nil && !feasible(dest, a)) {
+                continue
+            }
+            consider(dest, choice{nextHop: id, cost: linkCost + a.cost, path: a.path, seq: a.seq})
+        }
+    }
+    return routes
+}
+
+// hasID checks if a path goes through the server with the given ID
+func hasID(path []uint16, id uint16) bool {
+    for _, p := range path {
+        if p == id {
+            return true
+        }
+    }
+    return false
+}
+
+// addID returns a copy of a sorted list of IDs with another one added
+func addID(ids []uint16, id uint16) []uint16 {
+    if hasID(ids, id) {
+        return ids
+    }
+    added := make([]uint16, 0, len(ids) + 1)
+    added = append(added, ids...)
+    added = append(added, id)
+    sort.Slice(added, func(i, j int) bool {
+        return added[i] < added[j]
+    })
+    return added
+}
+
+// sameIDs checks if two lists of IDs, like paths, are the same
+func sameIDs(a, b []uint16) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+    return true
+}
+
+// recompute works out the best route to each destination and installs it.
+// Routes that became unreachable are expired. It reports whether any
+// route changed.
+func (e *Engine) recompute(now time.Time) bool {
+    var best map[uint16]choice
+    if e.cfg.Feasibility {
+        best = bestRoutes(e.id, e.cfg.Infinity, e.cfg, e.bidirectional(), e.ribIn, e.feasible)
+    } else {
+        best = bestRoutes(e.id, e.cfg.Infinity, e.cfg, e.links, e.ribIn, nil)
+    }
+
+    // Make sure we have an entry for every destination we've heard of
+    for dest := range best {
+        e.route(dest)
+    }
+
+    changed := false
+    for id, rt := range e.routes {
+        b, ok := best[id]
+        if !ok {
+            b = choice{nextHop: 0, cost: e.cfg.Infinity}
+        }
+
+        // While the route is held down we won't take a route
+        // that's worse than the one we lost
+        if now.Before(rt.heldUntil) && b.cost > rt.lastCost {
+            b = choice{nextHop: 0, cost: e.cfg.Infinity}
+        }
+
+        // We never go back to an older sequence number, which only
+        // happens when a neighbor we had routes from goes away
+        if e.cfg.Sequenced {
+            if b.seq < rt.seq {
+                b = choice{nextHop: 0, cost: e.cfg.Infinity, seq: rt.seq}
+            }
+
+            // Losing a route breaks it, so the next sequence number we
+            // advertise it with is odd. Only its destination can fix
+            // it, with a newer even one.
+            if !e.reachable(b.cost) && e.reachable(rt.cost) && b.seq % 2 == 0 {
+                b.seq++
+            }
+
+            // A newer sequence number on its own goes out with the next
+            // update, it doesn't trigger one
+            rt.seq = b.seq
+        }
+
+        // A route we lose keeps its sequence number, so that it's
+        // retracted with it. A newer one goes out right away, since
+        // someone may have asked for it.
+        if e.cfg.Feasibility {
+            if e.reachable(b.cost) {
+                e.updateFD(id, b.seq, b.cost)
+                if b.seq != rt.seq {
+                    rt.seq = b.seq
+                    changed = true
+                }
+            } else {
+                e.checkFeasible(id, now)
+            }
+        }
+
+        if b.nextHop == rt.nextHop && b.cost == rt.cost && sameIDs(b.path, rt.path) && sameIDs(b.hops, rt.hops) {
+            continue
+        }
+
+        prev := rt.cost
+        rt.nextHop = b.nextHop
+        rt.cost = b.cost
+        rt.path = b.path
+        rt.hops = b.hops
+
+        if e.reachable(rt.cost) {
+            e.refreshRoute(rt, now)
+        } else if e.reachable(prev) {
+            e.expireRoute(rt, prev, now)
+        }
+        changed = true
+    }
+    return changed
+}
@@ -0,0 +1,113 @@
+package dv
+
+import (
+    "dvr/types"
+    "time"
+)
+
+// routeState is where a route is in its lifecycle
+type routeState int
+
+const (
+    // routeDeleted routes have been garbage collected, or were never
+    // learned, and aren't advertised
+    routeDeleted routeState = iota
+    // routeValid routes are in use
+    routeValid
+    // routeExpired routes are advertised as unreachable until they're
+    // garbage collected
+    routeExpired
+)
+
+// String returns the name of the route state, for display
+func (s routeState) String() string {
+    switch s {
+    case routeValid:
+        return types.StateValid
+    case routeExpired:
+        return types.StateExpired
+    }
+    return types.StateDeleted
+}
+
+// checkRouteTimers expires the routes that haven't been advertised by their
+// next hop within the route timeout, and deletes the routes that have been
+// expired for longer than the garbage collection timeout
+func (e *Engine) checkRouteTimers(now time.Time) bool {
+    expired := false
+
+    for id, rt := range e.routes {
+        switch rt.state {
+        case routeValid:
+            // Our direct routes last as long as the link to the neighbor,
+            // which the router looks after
+            if rt.nextHop == id {
+                continue
+            }
+            if e.cfg.RouteTimeout == 0 || now.Sub(rt.refreshed) < e.cfg.RouteTimeout {
+                continue
+            }
+            e.log.OutError("\ne.checkRouteTimers: route to server %d via %d timed out\n", id, rt.nextHop)
+            e.log.OutApp("\nPlease enter a command: ")
+
+            // The next hop's advertisement is stale, so we forget it and
+            // find another route, if there is one
+            if vector, ok := e.ribIn[rt.nextHop]; ok {
+                vector[id] = advert{cost: e.cfg.Infinity, seq: vector[id].seq}
+            }
+            expired = true
+        case routeExpired:
+            if e.cfg.GarbageTimeout == 0 || now.Sub(rt.expired) < e.cfg.GarbageTimeout {
+                continue
+            }
+            rt.state = routeDeleted
+            rt.nextHop = 0
+            rt.hops = nil
+            rt.path = nil
+        }
+    }
+
+    if !expired {
+        return false
+    }
+    return e.recompute(now)
+}
+
+// refreshRoute marks a route as valid, restarting its timeout
+func (e *Engine) refreshRoute(rt *route, now time.Time) {
+    rt.state = routeValid
+    rt.refreshed = now
+}
+
+// expireRoute marks a route that just became unreachable as expired,
+// starting its garbage collection timer and hold-down
+func (e *Engine) expireRoute(rt *route, cost int, now time.Time) {
+    if rt.state != routeValid {
+        return
+    }
+    rt.state = routeExpired
+    rt.expired = now
+
+    if e.cfg.HoldDown > 0 {
+        rt.lastCost = cost
+        rt.heldUntil = now.Add(e.cfg.HoldDown)
+    }
+}
+
+// routeTimer returns how long is left until a route changes state
+func (e *Engine) routeTimer(id uint16, rt *route, now time.Time) time.Duration {
+    switch rt.state {
+    case routeValid:
+        // Direct routes don't time out
+        if e.cfg.RouteTimeout == 0 || rt.nextHop == id {
+            return 0
+        }
+        return rt.refreshed.Add(e.cfg.RouteTimeout).Sub(now)
+    case routeExpired:
+        if e.cfg.GarbageTimeout == 0 {
+            return 0
+        }
+        return rt.expired.Add(e.cfg.GarbageTimeout).Sub(now)
+    }
+    return 0
+}
@@ -0,0 +1,130 @@
+package dv
+
+import (
+    "dvr/log"
+    "dvr/message"
+    "errors"
+    "time"
+)
+
+// SplitErr is the error message to display for an unknown split horizon mode
+var SplitErr error = errors.New("split horizon mode must be one of 'off', 'simple' or 'poison'")
+
+// SplitHorizon is how a router advertises routes back to the neighbor
+// it learned them from
+type SplitHorizon int
+
+const (
+    // SplitHorizonOff advertises every route to every neighbor
+    SplitHorizonOff SplitHorizon = iota
+    // SplitHorizonSimple leaves routes out of the advertisement sent to
+    // the neighbor they were learned from
+    SplitHorizonSimple
+    // SplitHorizonPoison advertises routes back to the neighbor they were
+    // learned from with an infinite cost (poisoned reverse)
+    SplitHorizonPoison
+)
+
+// Config holds the options for a distance vector engine
+type Config struct {
+    // The cost at which a destination is unreachable
+    Infinity int
+
+    // How routes are advertised back to the neighbor they came from
+    SplitHorizon SplitHorizon
+
+    // Whether routes carry the list of servers they go through, so that
+    // routes that would loop back through us can be rejected
+    PathVector bool
+
+    // Whether routes carry the sequence number their destination stamped
+    // them with (DSDV), so that a newer route is preferred over a better
+    // one. Odd sequence numbers mark broken links.
+    Sequenced bool
+
+    // Whether routes are checked against the feasibility distance of their
+    // destination before they're used, like babel. Routes also carry
+    // sequence numbers, but only the destination changes its own, when
+    // it's asked to by a route request.
+    Feasibility bool
+
+    // How long a route that became unreachable ignores worse
+    // alternatives, 0 to turn hold-down off
+    HoldDown time.Duration
+
+    // How long a route lasts without being advertised by its next hop
+    // before it expires, 0 for routes to never expire
+    RouteTimeout time.Duration
+
+    // How long an expired route is advertised as unreachable before it's
+    // deleted, 0 for expired routes to never be deleted
+    GarbageTimeout time.Duration
+}
+
+// Engine is a distance vector routing engine, using the bellman-ford
+// algorithm over the vectors our neighbors advertise
+type Engine struct {
+    // Our own server ID
+    id uint16
+
+    // The sequence number we stamp our own entry with, in sequenced mode
+    seq uint32
+
+    cfg Config
+
+    // The cost of our link to each neighbor whose link is up
+    links map[uint16]int
+
+    // The distance vector each neighbor last advertised to us
+    ribIn ribIn
+
+    // In feasibility mode, the best route we've advertised to each
+    // destination, which neighbors we've heard from since their link came
+    // up and which of them have heard us
+    fd map[uint16]distance
+    heard map[uint16]bool
+    ihu map[uint16]bool
+
+    // In feasibility mode, the route requests waiting to be sent to each
+    // neighbor and when we last asked for a route to each destination
+    requests map[uint16][]message.Request
+    requested map[uint16]time.Time
+
+    // In feasibility mode, the destinations a neighbor asked for a route
+    // to that we already have, which the next update needs to carry
+    wanted map[uint16]bool
+
+    // Our route to each destination we know of
+    routes map[uint16]*route
+
+    log *log.Logger
+}
+
+// route is our route to a destination
+type route struct {
+    // The server that'll be hopped to in order to get to the destination,
+    // and the cost of getting there
+    nextHop uint16
+    cost int
+
+    // Every next hop with the same cost, sorted by ID
+    hops []uint16
+
+    // The servers the route goes through, starting with the next hop, in
+    // path vector mode
+    path []uint16
+
+    // The sequence number of the route, in sequenced mode
+    seq uint32
+
+    // The cost the route had before it became unreachable, and when the
+    // hold-down on it ends
+    lastCost int
+    heldUntil time.Time
+
+    // Where the route is in its lifecycle, the last time it was advertised
+    // by its next hop and the time it expired
+    state routeState
+    refreshed time.Time
+    expired time.Time
+}
@@ -0,0 +1,201 @@
+// Package ls provides a link state routing engine
+package ls
+
+import (
+    "dvr/log"
+    "dvr/message"
+    "dvr/types"
+    "sort"
+    "time"
+)
+
+// New initializes and returns a new link state engine for the server
+// with the given ID.
+func New(id uint16, cfg Config, l *log.Logger) *Engine {
+    e := Engine{
+        id: id,
+        cfg: cfg,
+        links: make(map[uint16]int),
+        lsdb: make(map[uint16]*lsa),
+        routes: make(map[uint16]*route),
+        log: l,
+    }
+
+    // We always have an advertisement of our own in the database
+    e.originate(time.Now())
+    return &e
+}
+
+// HandleUpdate installs the advertisements a neighbor flooded to us that
+// are newer than ours, and reruns dijkstra if there were any. Anything new
+// needs flooding on, even if our routes stayed the same.
+func (e *Engine) HandleUpdate(from uint16, msg *message.Message) bool {
+    // We only listen to neighbors we have a link to
+    if _, ok := e.links[from]; !ok || from == e.id {
+        return false
+    }
+    now := time.Now()
+
+    installed := false
+    for _, m := range msg.LSAs {
+        if e.install(m, now) {
+            installed = true
+        }
+    }
+    if !installed {
+        return false
+    }
+    e.recompute()
+    return true
+}
+
+// SetLinkCost sets the cost of our direct link to a neighbor, originating a
+// new advertisement if it changed
+func (e *Engine) SetLinkCost(id uint16, cost int) bool {
+    if id == e.id {
+        return false
+    }
+    e.route(id)
+
+    cost = e.metric(cost)
+    cur, ok := e.links[id]
+    if e.reachable(cost) {
+        if ok && cur == cost {
+            return false
+        }
+        e.links[id] = cost
+    } else {
+        if !ok {
+            return false
+        }
+        delete(e.links, id)
+    }
+
+    e.originate(time.Now())
+    e.recompute()
+    return true
+}
+
+// Periodic does nothing, link state floods changes as they happen and
+// refreshes its advertisement from Tick
+func (e *Engine) Periodic() {}
+
+// Tick flushes the advertisements that have aged out, and originates a
+// fresh one of our own before ours can
+func (e *Engine) Tick(now time.Time) bool {
+    changed := false
+    if e.flush(now) {
+        e.recompute()
+        changed = true
+    }
+    if e.cfg.MaxAge > 0 && now.Sub(e.originated) >= e.cfg.MaxAge/3 {
+        e.originate(now)
+        changed = true
+    }
+    return changed
+}
+
+// Forget drops our route to a destination that has left the network, and
+// its advertisement if we still have it. Neighbors we have a link to are
+// never forgotten.
+func (e *Engine) Forget(id uint16) {
+    if _, ok := e.links[id]; ok || id == e.id {
+        return
+    }
+    delete(e.routes, id)
+    delete(e.lsdb, id)
+}
+
+// Wanted returns nothing, every advertisement already carries the whole
+// database
+func (e *Engine) Wanted() []uint16 {
+    return nil
+}
+
+// Advertisement returns the whole link state database for the given
+// neighbor. Sending all of it every time means a lost packet is made up
+// for by the next one.
+func (e *Engine) Advertisement(to uint16) *message.Message {
+    msg := &message.Message{
+        N: make([]message.Neighbor, 0, len(e.routes) + 1),
+        LSAs: e.advertisements(time.Now()),
+    }
+
+    // The destinations we can reach go in as entries, which only carry
+    // their addresses, so that servers that joined while we were running
+    // can be learned of
+    for id, rt := range e.routes {
+        if e.reachable(rt.cost) {
+            msg.Set(message.Neighbor{ID: id, Cost: uint16(rt.cost)})
+        }
+    }
+
+    // The packet needs to include an entry to reach ourself with cost 0,
+    // and one for the neighbor so it's not taken for a link cost update
+    msg.Set(message.Neighbor{ID: e.id, Cost: 0})
+    cost := e.cfg.Infinity
+    if c, ok := e.links[to]; ok {
+        cost = c
+    }
+    msg.Set(message.Neighbor{ID: to, Cost: uint16(cost)})
+
+    msg.Updates = uint16(len(msg.N))
+    return msg
+}
+
+// Table returns our routes, sorted by destination ID. The timer is how long
+// until the destination's advertisement ages out.
+func (e *Engine) Table() []types.Route {
+    now := time.Now()
+    table := make([]types.Route, 0, len(e.routes))
+    for id, rt := range e.routes {
+        r := types.Route{
+            ID: id,
+            NextHop: rt.nextHop,
+            NextHops: rt.hops,
+            Cost: rt.cost,
+            State: types.StateDeleted,
+        }
+
+        // A route we can't use has expired, and is only deleted once the
+        // destination's advertisement has aged out
+        if e.reachable(rt.cost) {
+            r.State = types.StateValid
+        } else if _, ok := e.lsdb[id]; ok {
+            r.State = types.StateExpired
+        }
+        if l, ok := e.lsdb[id]; ok && e.cfg.MaxAge > 0 {
+            r.Timer = e.cfg.MaxAge - e.currentAge(l, now)
+        }
+        table = append(table, r)
+    }
+    sort.Slice(table, func(i, j int) bool {
+        return table[i].ID < table[j].ID
+    })
+    return table
+}
+
+// route returns our route to a destination, adding an unreachable one if
+// we don't have one yet
+func (e *Engine) route(id uint16) *route {
+    rt, ok := e.routes[id]
+    if !ok {
+        rt = &route{nextHop: 0, cost: e.cfg.Infinity}
+        e.routes[id] = rt
+    }
+    return rt
+}
+
+// metric caps a cost at our infinity, anything at or above it
+// is unreachable
+func (e *Engine) metric(cost int) int {
+    if cost >= e.cfg.Infinity {
+        return e.cfg.Infinity
+    }
+    return cost
+}
+
+// reachable checks if a cost is below our infinity
+func (e *Engine) reachable(cost int) bool {
+    return cost < e.cfg.Infinity
+}
@@ -0,0 +1,83 @@
+package ls
+
+import (
+    "dvr/log"
+    "dvr/message"
+    "testing"
+    "time"
+)
+
+func TestFloodConverges(t *testing.T) {
+    // Two neighbors flooding their databases to each other settle down,
+    // our own advertisement coming back to us isn't taken as newer
+    a := New(1, Config{Infinity: 16}, log.New())
+    b := New(2, Config{Infinity: 16}, log.New())
+    a.SetLinkCost(2, 3)
+    b.SetLinkCost(1, 3)
+
+    quiet := false
+    for i := 0; i < 10 && !quiet; i++ {
+        changedA := a.HandleUpdate(2, b.Advertisement(1))
+        changedB := b.HandleUpdate(1, a.Advertisement(2))
+        quiet = !changedA && !changedB
+    }
+    if !quiet {
+        t.Fatalf("engines still flooding, sequence numbers at %d and %d", a.seq, b.seq)
+    }
+    if a.seq != 2 || b.seq != 2 {
+        t.Errorf("sequence numbers are %d and %d, want 2", a.seq, b.seq)
+    }
+
+    for _, e := range []*Engine{a, b} {
+        to := 3 - e.id
+        if rt := e.routes[to]; rt == nil || rt.cost != 3 || rt.nextHop != to {
+            t.Errorf("server %d's route to %d = %+v, want cost 3 via %d", e.id, to, rt, to)
+        }
+    }
+}
+
+func TestInstallOwnRestart(t *testing.T) {
+    // An advertisement of ours from before a restart moves our sequence
+    // number past it, an echo of the current one doesn't
+    e := New(1, Config{Infinity: 16}, log.New())
+    e.SetLinkCost(2, 3)
+    seq := e.seq
+
+    if e.install(message.LSA{Origin: 1, Seq: seq}, time.Now()) {
+        t.Errorf("echo of our own advertisement was installed")
+    }
+    if e.seq != seq {
+        t.Errorf("sequence number moved to %d on an echo, want %d", e.seq, seq)
+    }
+    if !e.install(message.LSA{Origin: 1, Seq: seq + 5}, time.Now()) {
+        t.Errorf("newer advertisement of ours wasn't taken")
+    }
+    if e.seq != seq+6 {
+        t.Errorf("sequence number is %d, want %d", e.seq, seq+6)
+    }
+}
+
+func TestAdvertisementEntries(t *testing.T) {
+    // Every destination we can reach has an entry, so that the router can
+    // fill in its address for servers that haven't heard of it
+    a := New(1, Config{Infinity: 16}, log.New())
+    b := New(2, Config{Infinity: 16}, log.New())
+    c := New(3, Config{Infinity: 16}, log.New())
+    a.SetLinkCost(2, 3)
+    b.SetLinkCost(1, 3)
+    b.SetLinkCost(3, 4)
+    c.SetLinkCost(2, 4)
+    a.HandleUpdate(2, b.Advertisement(1))
+    a.HandleUpdate(2, c.Advertisement(2))
+
+    msg := a.Advertisement(2)
+    for _, want := range []message.Neighbor{{ID: 1, Cost: 0}, {ID: 2, Cost: 3}, {ID: 3, Cost: 7}} {
+        n, ok := msg.Entry(want.ID)
+        if !ok || n.Cost != want.Cost {
+            t.Errorf("entry for server %d = %+v, want cost %d", want.ID, n, want.Cost)
+        }
+    }
+    if int(msg.Updates) != len(msg.N) {
+        t.Errorf("message counts %d entries, has %d", msg.Updates, len(msg.N))
+    }
+}
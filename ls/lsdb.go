@@ -0,0 +1,114 @@
+package ls
+
+import (
+    "dvr/message"
+    "math"
+    "sort"
+    "time"
+)
+
+// currentAge returns how old an advertisement is now
+func (e *Engine) currentAge(l *lsa, now time.Time) time.Duration {
+    return l.age + now.Sub(l.received)
+}
+
+// aged checks if an advertisement has reached the max age
+func (e *Engine) aged(l *lsa, now time.Time) bool {
+    return e.cfg.MaxAge > 0 && e.currentAge(l, now) >= e.cfg.MaxAge
+}
+
+// originate installs a new advertisement of our own links, with the next
+// sequence number
+func (e *Engine) originate(now time.Time) {
+    e.seq++
+    e.originated = now
+
+    links := make(map[uint16]int, len(e.links))
+    for id, cost := range e.links {
+        links[id] = cost
+    }
+    e.lsdb[e.id] = &lsa{seq: e.seq, received: now, links: links}
+}
+
+// install takes in an advertisement flooded to us, and reports whether it
+// was newer than the one in our database
+func (e *Engine) install(m message.LSA, now time.Time) bool {
+    // Someone still has an advertisement of ours from before we
+    // restarted, so we carry on from its sequence number
+    if m.Origin == e.id {
+        if m.Seq > e.seq {
+            e.seq = m.Seq
+            e.originate(now)
+            return true
+        }
+        return false
+    }
+
+    if cur, ok := e.lsdb[m.Origin]; ok && m.Seq <= cur.seq {
+        return false
+    }
+
+    l := &lsa{
+        seq: m.Seq,
+        age: time.Duration(m.Age) * time.Second,
+        received: now,
+        links: make(map[uint16]int, len(m.Links)),
+    }
+    for _, link := range m.Links {
+        l.links[link.ID] = e.metric(int(link.Cost))
+    }
+
+    // Already too old to keep, no need to flood it any further
+    if e.aged(l, now) {
+        return false
+    }
+    e.lsdb[m.Origin] = l
+    return true
+}
+
+// flush removes the advertisements that have reached the max age, and
+// reports whether there were any
+func (e *Engine) flush(now time.Time) bool {
+    flushed := false
+    for origin, l := range e.lsdb {
+        if origin == e.id || !e.aged(l, now) {
+            continue
+        }
+        e.log.OutError("\ne.flush: link state advertisement from server %d aged out\n", origin)
+        e.log.OutApp("\nPlease enter a command: ")
+
+        delete(e.lsdb, origin)
+        flushed = true
+    }
+    return flushed
+}
+
+// advertisements returns the database as it goes out on the wire, sorted
+// by origin ID
+func (e *Engine) advertisements(now time.Time) []message.LSA {
+    lsas := make([]message.LSA, 0, len(e.lsdb))
+    for origin, l := range e.lsdb {
+        age := e.currentAge(l, now) / time.Second
+        if age > math.MaxUint16 {
+            age = math.MaxUint16
+        }
+
+        m := message.LSA{
+            Origin: origin,
+            Seq: l.seq,
+            Age: uint16(age),
+            Links: make([]message.Link, 0, len(l.links)),
+        }
+        for id, cost := range l.links {
+            m.Links = append(m.Links, message.Link{ID: id, Cost: uint16(cost)})
+        }
+        sort.Slice(m.Links, func(i, j int) bool {
+            return m.Links[i].ID < m.Links[j].ID
+        })
+        lsas = append(lsas, m)
+    }
+    sort.Slice(lsas, func(i, j int) bool {
+        return lsas[i].Origin < lsas[j].Origin
+    })
+    return lsas
+}
@@ -0,0 +1,150 @@
+package ls
+
+import "sort"
+
+// choice is the best way we know of to reach a destination
+type choice struct {
+    nextHop uint16
+    cost int
+
+    // Every next hop with the same cost, sorted by ID
+    hops []uint16
+}
+
+// twoWay checks if both ends of a link advertise it, a link only one end
+// knows about can't be used
+func (e *Engine) twoWay(from, to uint16) bool {
+    l, ok := e.lsdb[to]
+    if !ok {
+        return false
+    }
+    _, ok = l.links[from]
+    return ok
+}
+
+// shortestPaths runs dijkstra's algorithm over the link state database,
+// working out the cost of the shortest path to each destination and the
+// neighbors the shortest paths start at. Ties go to the lowest next hop ID,
+// so the same database always gives the same routes. Destinations we can't
+// reach are left out.
+func (e *Engine) shortestPaths() map[uint16]choice {
+    best := map[uint16]choice{e.id: {nextHop: 0, cost: 0}}
+    done := make(map[uint16]bool, len(e.lsdb))
+
+    for {
+        // Pick the closest server we haven't visited yet
+        var u uint16
+        found := false
+        for id, c := range best {
+            if done[id] {
+                continue
+            }
+            if !found || c.cost < best[u].cost || (c.cost == best[u].cost && id < u) {
+                u = id
+                found = true
+            }
+        }
+        if !found {
+            break
+        }
+        done[u] = true
+
+        l, ok := e.lsdb[u]
+        if !ok {
+            continue
+        }
+        for v, cost := range l.links {
+            if done[v] || !e.twoWay(u, v) {
+                continue
+            }
+            c := choice{nextHop: best[u].nextHop, cost: best[u].cost + cost, hops: best[u].hops}
+            if u == e.id {
+                c.nextHop = v
+                c.hops = []uint16{v}
+            }
+            if !e.reachable(c.cost) {
+                continue
+            }
+
+            // An equal cost path adds its next hops to the ones we have
+            cur, ok := best[v]
+            if ok && c.cost == cur.cost {
+                cur.hops = mergeIDs(cur.hops, c.hops)
+                cur.nextHop = cur.hops[0]
+                best[v] = cur
+                continue
+            }
+            if !ok || c.cost < cur.cost {
+                best[v] = c
+            }
+        }
+    }
+
+    delete(best, e.id)
+    return best
+}
+
+// recompute works out the best route to each destination and installs it,
+// reporting whether any route changed
+func (e *Engine) recompute() bool {
+    best := e.shortestPaths()
+
+    // Make sure we have an entry for every destination we've heard of
+    for dest := range best {
+        e.route(dest)
+    }
+
+    changed := false
+    for id, rt := range e.routes {
+        b, ok := best[id]
+        if !ok {
+            b = choice{nextHop: 0, cost: e.cfg.Infinity}
+        }
+        if b.nextHop == rt.nextHop && b.cost == rt.cost && sameIDs(b.hops, rt.hops) {
+            continue
+        }
+        rt.nextHop = b.nextHop
+        rt.cost = b.cost
+        rt.hops = b.hops
+        changed = true
+    }
+    return changed
+}
+
+// mergeIDs returns the sorted union of two lists of IDs
+func mergeIDs(a, b []uint16) []uint16 {
+    merged := make([]uint16, 0, len(a) + len(b))
+    merged = append(merged, a...)
+    for _, id := range b {
+        if !hasID(merged, id) {
+            merged = append(merged, id)
+        }
+    }
+    sort.Slice(merged, func(i, j int) bool {
+        return merged[i] < merged[j]
+    })
+    return merged
+}
+
+// hasID checks if a list of IDs has the given one
+func hasID(ids []uint16, id uint16) bool {
+    for _, i := range ids {
+        if i == id {
+            return true
+        }
+    }
+    return false
+}
+
+// sameIDs checks if two lists of IDs are the same
+func sameIDs(a, b []uint16) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+    return true
+}
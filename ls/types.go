@@ -0,0 +1,65 @@
+package ls
+
+import (
+    "dvr/log"
+    "time"
+)
+
+// Config holds the options for a link state engine
+type Config struct {
+    // The cost at which a destination is unreachable
+    Infinity int
+
+    // How old a link state advertisement can get before it's flushed from
+    // the database, 0 for advertisements to never age out. We originate
+    // a fresh advertisement every third of this.
+    MaxAge time.Duration
+}
+
+// Engine is a link state routing engine. Every server floods an
+// advertisement of its own links, and we run dijkstra's algorithm over
+// the database of advertisements we've collected.
+type Engine struct {
+    // Our own server ID
+    id uint16
+
+    cfg Config
+
+    // The cost of our link to each neighbor whose link is up
+    links map[uint16]int
+
+    // The sequence number of our own advertisement and when we
+    // originated it
+    seq uint32
+    originated time.Time
+
+    // The link state database, the newest advertisement from each server
+    lsdb map[uint16]*lsa
+
+    // Our route to each destination we know of
+    routes map[uint16]*route
+
+    log *log.Logger
+}
+
+// lsa is a link state advertisement in the database
+type lsa struct {
+    seq uint32
+
+    // The age the advertisement had when we received it, and when
+    // that was
+    age time.Duration
+    received time.Time
+
+    // The cost of each of the origin's links
+    links map[uint16]int
+}
+
+// route is our route to a destination
+type route struct {
+    nextHop uint16
+    cost int
+
+    // Every next hop with the same cost, sorted by ID
+    hops []uint16
+}
@@ -0,0 +1,532 @@
+package message
+
+import (
+	"dvr/metric"
+	"encoding/binary"
+	"hash/crc32"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// ErrTruncated is the error returned when a message ends before what it says it holds
+var ErrTruncated error = errors.New("message is truncated")
+
+// ErrCountMismatch is the error returned when a count or length in a message doesn't match what follows it
+var ErrCountMismatch error = errors.New("message count doesn't match its contents")
+
+// ErrDuplicateEntry is the error returned when a message holds the same server twice
+var ErrDuplicateEntry error = errors.New("message has a duplicate entry")
+
+// ErrChecksum is the error returned when a message doesn't match its checksum
+var ErrChecksum error = errors.New("message failed its checksum")
+
+// The update message has the number of update fields and the sender's port
+// before its address, and each entry has its port, null bytes, ID and cost
+// after its address
+const (
+	updateSize = 4
+	entrySize  = 8
+)
+
+// Decoder unmarshals messages. The entries, addresses, paths, links and
+// requests of the messages it decodes are kept in slices that are reused
+// from one message to the next, so decoding doesn't need an allocation per
+// entry, and a message is only good until the next one is decoded.
+type Decoder struct {
+	entries  []Neighbor
+	addrs    []byte
+	ids      []uint16
+	links    []Link
+	lsas     []LSA
+	requests []Request
+	probe    Probe
+	hello    Hello
+
+	// The sections, until the entries they belong to are decoded
+	paths   []pathSection
+	seqs    []seqSection
+	metrics []metricSection
+
+	// The index of each server's entry, and the sections it had
+	index map[uint16]int
+	seen  []uint16
+}
+
+// pathSection is an entry in the paths section
+type pathSection struct {
+	id   uint16
+	path []uint16
+}
+
+// seqSection is an entry in the sequence numbers section
+type seqSection struct {
+	id  uint16
+	seq uint32
+}
+
+// metricSection is an entry in the metrics section
+type metricSection struct {
+	id uint16
+	m  metric.Link
+}
+
+// UnmarshalMessage unmarshals an update message into a message struct
+//
+// Unmarshaled message should like like:
+//  {Version:1 Type:1 Updates:3 Port:2001 IP:192.168.200.80 N:[...]}
+//
+// With the entries, sorted by ID, being
+//  {IP:192.168.200.80 Port:2000 ID:1 Cost:7}
+//  {IP:192.168.200.80 Port:2001 ID:2 Cost:0}
+//  {IP:192.168.200.80 Port:2002 ID:3 Cost:2}
+//
+// The message gets storage of its own, use a Decoder to reuse it.
+func UnmarshalMessage(msg []byte, m *Message) error {
+	var d Decoder
+	return d.Decode(msg, m)
+}
+
+// Decode unmarshals a message into a message struct, using the decoder's
+// storage for its entries.
+//
+// A message that fails its checksum is still decoded as far as it can be,
+// so the caller can tell who it says it's from, but nothing in it can be
+// trusted and ErrChecksum is returned.
+func (d *Decoder) Decode(msg []byte, m *Message) error {
+	// Did we actually get a message struct?
+	if m == nil {
+		return errors.Errorf("header must be non-nil")
+	}
+	d.reset()
+	*m = Message{}
+
+	// Does the message have a header, with more than a distance vector?
+	var flags uint16
+	var corrupt error
+	if len(msg) >= 2 && binary.BigEndian.Uint16(msg) == Magic {
+		var err error
+		msg, flags, err = unmarshalHeader(msg, m)
+		if errors.Is(err, ErrChecksum) {
+			corrupt = err
+		} else if err != nil {
+			return err
+		}
+	}
+
+	err := d.decodeBody(msg, flags, m)
+	if corrupt != nil {
+		return corrupt
+	}
+	return err
+}
+
+// decodeBody reads what follows the header, if the message has one
+func (d *Decoder) decodeBody(msg []byte, flags uint16, m *Message) error {
+	if m.Version != 0 {
+		var err error
+		if msg, err = d.decodeType(msg, m); err != nil {
+			return err
+		}
+		if msg, err = d.decodeSections(msg, flags, m); err != nil {
+			return err
+		}
+	}
+
+	if err := d.decodeUpdate(msg, flags&FlagIPv6 != 0, m); err != nil {
+		return err
+	}
+	if err := d.attachSections(); err != nil {
+		return err
+	}
+
+	// Servers can be listed in any order, but they're kept sorted by ID
+	m.N = d.entries
+	m.Sort()
+
+	// Without a header, the update command's messages are told apart by
+	// their single entry, routing updates always hold at least two
+	if m.Version == 0 {
+		m.Type = TypeUpdate
+		if len(d.entries) == 1 {
+			m.Type = TypeLinkCost
+		}
+	}
+	return nil
+}
+
+// reset empties the decoder's storage, keeping what it has allocated
+func (d *Decoder) reset() {
+	d.entries = d.entries[:0]
+	d.addrs = d.addrs[:0]
+	d.ids = d.ids[:0]
+	d.links = d.links[:0]
+	d.lsas = d.lsas[:0]
+	d.requests = d.requests[:0]
+	d.paths = d.paths[:0]
+	d.seqs = d.seqs[:0]
+	d.metrics = d.metrics[:0]
+	d.seen = d.seen[:0]
+	if d.index == nil {
+		d.index = make(map[uint16]int)
+	}
+	for id := range d.index {
+		delete(d.index, id)
+	}
+}
+
+// unmarshalHeader reads the header from the front of msg into the message,
+// returning the rest of the message, without its checksum, and the header's
+// flags. The rest of the message is still returned when it fails its
+// checksum.
+func unmarshalHeader(msg []byte, m *Message) ([]byte, uint16, error) {
+	if len(msg) < HeaderSize {
+		return nil, 0, errors.Wrapf(ErrTruncated, "header is %d bytes", len(msg))
+	}
+	m.Version = msg[2]
+	m.Type = msg[3]
+	flags := binary.BigEndian.Uint16(msg[4:])
+	length := int(binary.BigEndian.Uint16(msg[6:]))
+
+	if m.Version != Version {
+		return nil, 0, errors.Errorf("unsupported message version %d", m.Version)
+	}
+	if m.Type < TypeUpdate || m.Type > TypeProbe {
+		return nil, 0, errors.Errorf("unknown message type %d", m.Type)
+	}
+	if flags&^knownFlags != 0 {
+		return nil, 0, errors.Errorf("unknown message flags %#x", flags&^knownFlags)
+	}
+	m.Partial = flags&FlagPartial != 0
+
+	body := msg[HeaderSize:]
+	if len(body) < length {
+		return nil, 0, errors.Wrapf(ErrTruncated, "message is %d bytes after the header, its header says %d", len(body), length)
+	}
+	if len(body) > length {
+		return nil, 0, errors.Wrapf(ErrCountMismatch, "message is %d bytes after the header, its header says %d", len(body), length)
+	}
+
+	if flags&FlagChecksum != 0 {
+		if len(body) < checksumSize {
+			return nil, 0, errors.Wrapf(ErrTruncated, "checksum is %d bytes", len(body))
+		}
+		end := len(msg) - checksumSize
+		body = body[:len(body)-checksumSize]
+		m.Checksum = true
+
+		want := binary.BigEndian.Uint32(msg[end:])
+		if got := crc32.Checksum(msg[:end], castagnoli); got != want {
+			return body, flags, errors.Wrapf(ErrChecksum, "checksum is %#08x, the message's is %#08x", want, got)
+		}
+	}
+	return body, flags, nil
+}
+
+// decodeType reads what the message type carries from the front of msg,
+// returning the rest of it
+func (d *Decoder) decodeType(msg []byte, m *Message) ([]byte, error) {
+	switch m.Type {
+	case TypeLinkState:
+		return d.decodeLSAs(msg, m)
+	case TypeProbe:
+		if len(msg) < 14 {
+			return nil, errors.Wrapf(ErrTruncated, "probe is %d bytes", len(msg))
+		}
+		d.probe = Probe{
+			Seq:   binary.BigEndian.Uint32(msg),
+			Sent:  int64(binary.BigEndian.Uint64(msg[4:])),
+			Flags: binary.BigEndian.Uint16(msg[12:]),
+		}
+		m.Probe = &d.probe
+		return msg[14:], nil
+	case TypeHello:
+		if len(msg) < 6 {
+			return nil, errors.Wrapf(ErrTruncated, "hello is %d bytes", len(msg))
+		}
+		d.hello = Hello{
+			Interval:   binary.BigEndian.Uint32(msg),
+			Multiplier: binary.BigEndian.Uint16(msg[4:]),
+		}
+		m.Hello = &d.hello
+		return msg[6:], nil
+	}
+	return msg, nil
+}
+
+// decodeLSAs reads link state advertisements from the front of msg,
+// returning the rest of it
+func (d *Decoder) decodeLSAs(msg []byte, m *Message) ([]byte, error) {
+	if len(msg) < 2 {
+		return nil, errors.Wrapf(ErrTruncated, "link state advertisements are missing their count")
+	}
+	count := int(binary.BigEndian.Uint16(msg))
+	b := 2
+
+	for i := 0; i < count; i++ {
+		// Each LSA has a 10 byte header and 4 bytes per link
+		if len(msg) < b+10 {
+			return nil, errors.Wrapf(ErrTruncated, "link state advertisement %d of %d", i+1, count)
+		}
+		l := LSA{
+			Origin: binary.BigEndian.Uint16(msg[b:]),
+			Seq:    binary.BigEndian.Uint32(msg[b+2:]),
+			Age:    binary.BigEndian.Uint16(msg[b+6:]),
+		}
+		links := int(binary.BigEndian.Uint16(msg[b+8:]))
+		b += 10
+
+		if len(msg) < b+4*links {
+			return nil, errors.Wrapf(ErrTruncated, "links of link state advertisement %d of %d", i+1, count)
+		}
+		for _, other := range d.lsas {
+			if other.Origin == l.Origin {
+				return nil, errors.Wrapf(ErrDuplicateEntry, "two link state advertisements from server %d", l.Origin)
+			}
+		}
+
+		start := len(d.links)
+		for j := 0; j < links; j++ {
+			d.links = append(d.links, Link{
+				ID:   binary.BigEndian.Uint16(msg[b:]),
+				Cost: binary.BigEndian.Uint16(msg[b+2:]),
+			})
+			b += 4
+		}
+		l.Links = d.links[start:len(d.links):len(d.links)]
+		d.lsas = append(d.lsas, l)
+	}
+	m.LSAs = d.lsas
+	return msg[b:], nil
+}
+
+// decodeSections reads the sections the flags say are there from the front
+// of msg, in the order of their flags, returning the rest of it
+func (d *Decoder) decodeSections(msg []byte, flags uint16, m *Message) ([]byte, error) {
+	var err error
+	if flags&FlagPaths != 0 {
+		if msg, err = d.decodePaths(msg); err != nil {
+			return nil, err
+		}
+	}
+	if flags&FlagSeqs != 0 {
+		if msg, err = d.decodeSeqs(msg); err != nil {
+			return nil, err
+		}
+	}
+	if flags&FlagRequests != 0 {
+		if msg, err = d.decodeRequests(msg); err != nil {
+			return nil, err
+		}
+		m.Requests = d.requests
+	}
+	if flags&FlagMetrics != 0 {
+		if msg, err = d.decodeMetrics(msg); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// decodePaths reads the paths section from the front of msg, returning the
+// rest of it
+func (d *Decoder) decodePaths(msg []byte) ([]byte, error) {
+	if len(msg) < 2 {
+		return nil, errors.Wrapf(ErrTruncated, "paths are missing their count")
+	}
+	count := int(binary.BigEndian.Uint16(msg))
+	b := 2
+
+	for i := 0; i < count; i++ {
+		if len(msg) < b+4 {
+			return nil, errors.Wrapf(ErrTruncated, "path %d of %d", i+1, count)
+		}
+		id := binary.BigEndian.Uint16(msg[b:])
+		length := int(binary.BigEndian.Uint16(msg[b+2:]))
+		b += 4
+
+		if len(msg) < b+2*length {
+			return nil, errors.Wrapf(ErrTruncated, "path %d of %d", i+1, count)
+		}
+		start := len(d.ids)
+		for j := 0; j < length; j++ {
+			d.ids = append(d.ids, binary.BigEndian.Uint16(msg[b:]))
+			b += 2
+		}
+		d.paths = append(d.paths, pathSection{id: id, path: d.ids[start:len(d.ids):len(d.ids)]})
+	}
+	return msg[b:], nil
+}
+
+// decodeSeqs reads the sequence numbers section from the front of msg,
+// returning the rest of it
+func (d *Decoder) decodeSeqs(msg []byte) ([]byte, error) {
+	if len(msg) < 2 {
+		return nil, errors.Wrapf(ErrTruncated, "sequence numbers are missing their count")
+	}
+	count := int(binary.BigEndian.Uint16(msg))
+	b := 2
+
+	// Each sequence number takes 6 bytes, with its server ID
+	if len(msg) < b+6*count {
+		return nil, errors.Wrapf(ErrTruncated, "%d sequence numbers in %d bytes", count, len(msg)-b)
+	}
+	for i := 0; i < count; i++ {
+		d.seqs = append(d.seqs, seqSection{
+			id:  binary.BigEndian.Uint16(msg[b:]),
+			seq: binary.BigEndian.Uint32(msg[b+2:]),
+		})
+		b += 6
+	}
+	return msg[b:], nil
+}
+
+// decodeRequests reads the route requests section from the front of msg,
+// returning the rest of it
+func (d *Decoder) decodeRequests(msg []byte) ([]byte, error) {
+	if len(msg) < 2 {
+		return nil, errors.Wrapf(ErrTruncated, "route requests are missing their count")
+	}
+	count := int(binary.BigEndian.Uint16(msg))
+	b := 2
+
+	// Each request takes 8 bytes
+	if len(msg) < b+8*count {
+		return nil, errors.Wrapf(ErrTruncated, "%d route requests in %d bytes", count, len(msg)-b)
+	}
+	for i := 0; i < count; i++ {
+		d.requests = append(d.requests, Request{
+			ID:   binary.BigEndian.Uint16(msg[b:]),
+			Seq:  binary.BigEndian.Uint32(msg[b+2:]),
+			Hops: binary.BigEndian.Uint16(msg[b+6:]),
+		})
+		b += 8
+	}
+	return msg[b:], nil
+}
+
+// decodeMetrics reads the metrics section from the front of msg, returning
+// the rest of it
+func (d *Decoder) decodeMetrics(msg []byte) ([]byte, error) {
+	if len(msg) < 2 {
+		return nil, errors.Wrapf(ErrTruncated, "link attributes are missing their count")
+	}
+	count := int(binary.BigEndian.Uint16(msg))
+	b := 2
+
+	// Each set of attributes takes 14 bytes, with its server ID
+	if len(msg) < b+14*count {
+		return nil, errors.Wrapf(ErrTruncated, "%d sets of link attributes in %d bytes", count, len(msg)-b)
+	}
+	for i := 0; i < count; i++ {
+		d.metrics = append(d.metrics, metricSection{
+			id: binary.BigEndian.Uint16(msg[b:]),
+			m: metric.Link{
+				Set:       metric.Attr(binary.BigEndian.Uint16(msg[b+2:])),
+				Delay:     int(binary.BigEndian.Uint32(msg[b+4:])),
+				Bandwidth: int(binary.BigEndian.Uint32(msg[b+8:])),
+				Loss:      int(binary.BigEndian.Uint16(msg[b+12:])),
+			},
+		})
+		b += 14
+	}
+	return msg[b:], nil
+}
+
+// decodeUpdate reads the update message, in the assignment's format, which
+// has to be all that's left of msg. Its addresses are IPv6 addresses if
+// ipv6 is set, or IPv4 addresses.
+func (d *Decoder) decodeUpdate(msg []byte, ipv6 bool, m *Message) error {
+	addrSize := net.IPv4len
+	if ipv6 {
+		addrSize = net.IPv6len
+	}
+	if len(msg) < updateSize+addrSize {
+		return errors.Wrapf(ErrTruncated, "update message is %d bytes", len(msg))
+	}
+
+	// As this comes from the network in UDP packets we can assume that it
+	// comes in BigEndian (network byte order).
+	m.Updates = binary.BigEndian.Uint16(msg)
+	m.Port = binary.BigEndian.Uint16(msg[2:])
+	m.IP = d.addr(msg[updateSize : updateSize+addrSize])
+
+	// Every update message has at least the sender's own entry, and
+	// exactly as many entries as its count says
+	entries := msg[updateSize+addrSize:]
+	size := addrSize + entrySize
+	want := size * int(m.Updates)
+	switch {
+	case m.Updates == 0:
+		return errors.Wrapf(ErrCountMismatch, "update message has no entries")
+	case len(entries) < want:
+		return errors.Wrapf(ErrTruncated, "%d entries in %d bytes", m.Updates, len(entries))
+	case len(entries) > want:
+		return errors.Wrapf(ErrCountMismatch, "%d entries in %d bytes", m.Updates, len(entries))
+	}
+
+	for b := 0; b < len(entries); b += size {
+		e := entries[b : b+size]
+		n := Neighbor{
+			IP:   d.addr(e[:addrSize]),
+			Port: binary.BigEndian.Uint16(e[addrSize:]),
+			ID:   binary.BigEndian.Uint16(e[addrSize+4:]),
+			Cost: binary.BigEndian.Uint16(e[addrSize+6:]),
+		}
+		if _, ok := d.index[n.ID]; ok {
+			return errors.Wrapf(ErrDuplicateEntry, "two entries for server %d", n.ID)
+		}
+		d.index[n.ID] = len(d.entries)
+		d.entries = append(d.entries, n)
+		d.seen = append(d.seen, 0)
+	}
+	return nil
+}
+
+// attachSections gives each entry what the sections hold for it. A section
+// can't hold a server twice, or one the update message doesn't have.
+func (d *Decoder) attachSections() error {
+	for _, p := range d.paths {
+		n, err := d.entry(p.id, FlagPaths, "paths")
+		if err != nil {
+			return err
+		}
+		n.Path = p.path
+	}
+	for _, s := range d.seqs {
+		n, err := d.entry(s.id, FlagSeqs, "sequence numbers")
+		if err != nil {
+			return err
+		}
+		n.Seq = s.seq
+	}
+	for _, mt := range d.metrics {
+		n, err := d.entry(mt.id, FlagMetrics, "link attributes")
+		if err != nil {
+			return err
+		}
+		n.Metrics = mt.m
+	}
+	return nil
+}
+
+// entry returns the entry a section holds something for, making sure the
+// section doesn't hold it twice
+func (d *Decoder) entry(id uint16, flag uint16, section string) (*Neighbor, error) {
+	i, ok := d.index[id]
+	if !ok {
+		return nil, errors.Wrapf(ErrCountMismatch, "%s for server %d, which has no entry", section, id)
+	}
+	if d.seen[i]&flag != 0 {
+		return nil, errors.Wrapf(ErrDuplicateEntry, "two %s for server %d", section, id)
+	}
+	d.seen[i] |= flag
+	return &d.entries[i], nil
+}
+
+// addr copies an address into the decoder's storage
+func (d *Decoder) addr(b []byte) net.IP {
+	start := len(d.addrs)
+	d.addrs = append(d.addrs, b...)
+	return net.IP(d.addrs[start:len(d.addrs):len(d.addrs)])
+}
@@ -0,0 +1,197 @@
+package message
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// The update message both golden packets carry: server 1 at 10.0.0.1:2001
+// with its own entry and an entry for server 2 at 10.0.0.2:2002, cost 3
+var updateBody = []byte{
+	0x00, 0x02, 0x07, 0xd1, 0x0a, 0x00, 0x00, 0x01,
+	0x0a, 0x00, 0x00, 0x01, 0x07, 0xd1, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00,
+	0x0a, 0x00, 0x00, 0x02, 0x07, 0xd2, 0x00, 0x00, 0x00, 0x02, 0x00, 0x03,
+}
+
+// goldenUpdate is updateBody with a header in front of it
+var goldenUpdate = append([]byte{0xd7, 0xc5, 0x01, 0x01, 0x00, 0x00, 0x00, 0x20}, updateBody...)
+
+// goldenChecksum is updateBody with a header and a checksum
+var goldenChecksum = append(append([]byte{0xd7, 0xc5, 0x01, 0x01, 0x00, 0x20, 0x00, 0x24}, updateBody...), 0x76, 0xdd, 0x06, 0x51)
+
+// goldenMessage is the message the golden packets hold
+func goldenMessage() *Message {
+	return &Message{
+		Updates: 2,
+		Port:    2001,
+		IP:      net.ParseIP("10.0.0.1"),
+		N: []Neighbor{
+			{IP: net.ParseIP("10.0.0.1"), Port: 2001, ID: 1, Cost: 0},
+			{IP: net.ParseIP("10.0.0.2"), Port: 2002, ID: 2, Cost: 3},
+		},
+	}
+}
+
+// packet returns a copy of a packet with the bytes at i replaced
+func packet(p []byte, i int, b ...byte) []byte {
+	c := append([]byte(nil), p...)
+	copy(c[i:], b)
+	return c
+}
+
+func TestMarshalGolden(t *testing.T) {
+	tests := []struct {
+		name   string
+		legacy bool
+		sum    bool
+		want   []byte
+	}{
+		{"legacy", true, false, updateBody},
+		{"header", false, false, goldenUpdate},
+		{"checksum", false, true, goldenChecksum},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := goldenMessage()
+			m.Checksum = tt.sum
+
+			var got []byte
+			var err error
+			if tt.legacy {
+				got, err = m.MarshalLegacy()
+			} else {
+				got, err = m.Marshal()
+			}
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("marshal = % x, want % x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeGolden(t *testing.T) {
+	tests := []struct {
+		name    string
+		packet  []byte
+		version uint8
+		typ     uint8
+		sum     bool
+	}{
+		{"legacy", updateBody, 0, TypeUpdate, false},
+		{"header", goldenUpdate, Version, TypeUpdate, false},
+		{"checksum", goldenChecksum, Version, TypeUpdate, true},
+		{"legacy link cost", []byte{
+			0x00, 0x01, 0x07, 0xd1, 0x0a, 0x00, 0x00, 0x01,
+			0x0a, 0x00, 0x00, 0x02, 0x07, 0xd2, 0x00, 0x00, 0x00, 0x02, 0x00, 0x03,
+		}, 0, TypeLinkCost, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m Message
+			if err := UnmarshalMessage(tt.packet, &m); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if m.Version != tt.version || m.Type != tt.typ || m.Checksum != tt.sum {
+				t.Errorf("version %d, type %d, checksum %t, want %d, %d, %t", m.Version, m.Type, m.Checksum, tt.version, tt.typ, tt.sum)
+			}
+			if m.Port != 2001 || !m.IP.Equal(net.ParseIP("10.0.0.1")) {
+				t.Errorf("sender %s:%d, want 10.0.0.1:2001", m.IP, m.Port)
+			}
+			n, ok := m.Entry(2)
+			if !ok || n.Cost != 3 || n.Port != 2002 || !n.IP.Equal(net.ParseIP("10.0.0.2")) {
+				t.Errorf("entry for server 2 = %+v, want 10.0.0.2:2002 cost 3", n)
+			}
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet []byte
+		want   error
+	}{
+		{"empty", nil, ErrTruncated},
+		{"short header", goldenUpdate[:HeaderSize-1], ErrTruncated},
+		{"short update", updateBody[:6], ErrTruncated},
+		{"short entry", updateBody[:len(updateBody)-1], ErrTruncated},
+		{"short body", goldenUpdate[:len(goldenUpdate)-1], ErrTruncated},
+		{"short checksum", packet(goldenChecksum[:HeaderSize+2], 6, 0x00, 0x02), ErrTruncated},
+		{"count above entries", packet(updateBody, 0, 0x00, 0x03), ErrTruncated},
+		{"count below entries", packet(updateBody, 0, 0x00, 0x01), ErrCountMismatch},
+		{"no entries", packet(updateBody[:8], 0, 0x00, 0x00), ErrCountMismatch},
+		{"oversized body", append(append([]byte(nil), goldenUpdate...), 0x00), ErrCountMismatch},
+		{"length below body", packet(goldenUpdate, 6, 0x00, 0x1f), ErrCountMismatch},
+		{"duplicate entry", packet(updateBody, 8+12+8, 0x00, 0x01), ErrDuplicateEntry},
+		{"bad checksum", packet(goldenChecksum, len(goldenChecksum)-1, 0x00), ErrChecksum},
+		{"corrupted cost", packet(goldenChecksum, len(goldenChecksum)-5, 0x01), ErrChecksum},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m Message
+			err := UnmarshalMessage(tt.packet, &m)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("unmarshal error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeBadHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet []byte
+	}{
+		{"version", packet(goldenUpdate, 2, 0x02)},
+		{"type", packet(goldenUpdate, 3, 0x09)},
+		{"flags", packet(goldenUpdate, 4, 0x80, 0x00)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m Message
+			if err := UnmarshalMessage(tt.packet, &m); err == nil {
+				t.Errorf("unmarshal of % x succeeded", tt.packet)
+			}
+		})
+	}
+}
+
+func TestDecodeChecksumSender(t *testing.T) {
+	// A message that fails its checksum still says who it's from
+	var m Message
+	err := UnmarshalMessage(packet(goldenChecksum, len(goldenChecksum)-5, 0x01), &m)
+	if !errors.Is(err, ErrChecksum) {
+		t.Fatalf("unmarshal error = %v, want %v", err, ErrChecksum)
+	}
+	if m.Port != 2001 {
+		t.Errorf("port = %d, want 2001", m.Port)
+	}
+}
+
+func TestDecoderReuse(t *testing.T) {
+	// Decoding one message after another gives each its own contents, and
+	// a warm decoder doesn't allocate
+	var d Decoder
+	var m Message
+	for _, p := range [][]byte{goldenUpdate, updateBody, goldenChecksum} {
+		if err := d.Decode(p, &m); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if !m.Equal(goldenMessage()) {
+			t.Errorf("decoded %+v, want %+v", m, goldenMessage())
+		}
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		d.Decode(goldenChecksum, &m)
+	})
+	if allocs != 0 {
+		t.Errorf("decode made %v allocations, want 0", allocs)
+	}
+}
@@ -2,10 +2,11 @@ package message
 
 import (
 	"bytes"
+	"dvr/metric"
 	"encoding/binary"
-	"fmt"
-	"strconv"
-	"strings"
+	"hash/crc32"
+	"math"
+	"net"
 
 	"github.com/pkg/errors"
 )
@@ -40,6 +41,132 @@ import (
 //   +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
 //
 
+// MaxCost is the largest link cost that can be carried in a message
+const MaxCost = math.MaxUint16
+
+// Messages that carry more than a distance vector start with a header,
+// which an update message can't start with since it would need Magic
+// update fields:
+//
+//   +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//   |                    MAGIC                      |
+//   +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//   |        VERSION        |         TYPE          |
+//   +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//   |                    FLAGS                      |
+//   +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//   |   LENGTH OF THE MESSAGE AFTER THE HEADER      |
+//   +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//
+// The header is followed by what the message type carries, then the
+// sections the flags say are there, in the order of the flags, and then
+// the update message itself. The address family flag makes every address
+// in the update message an IPv6 address, 16 bytes long, so its entries
+// take 24 bytes instead of 12. IPv4 addresses are then sent IPv4-mapped.
+// The checksum flag adds a CRC32C of everything before it, header and all,
+// to the end of the message, which the length counts. The partial flag says
+// a routing update only carries the routes that changed, so what it leaves
+// out hasn't been withdrawn.
+//
+// A link state message carries its advertisements:
+//
+//   +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//   |        NUMBER OF LINK STATE ADVERTISEMENTS    |
+//   +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//   |  ORIGIN ID  |  SEQUENCE NUMBER (4) |    AGE   |
+//   +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//   |  NUMBER OF LINKS |  LINK 1 ID  | LINK 1 COST  | ...
+//   +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//
+// a probe message, used to measure the round trip time of a link, carries:
+//
+//   +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//   |  PROBE SEQUENCE NUMBER (4) |  SENT TIME (8) ...
+//   +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//   ...                          |      FLAGS       |
+//   +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//
+// and a hello message, which only says the sender is alive, carries:
+//
+//   +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//   |  HELLO INTERVAL IN MS (4)  | DEAD MULTIPLIER  |
+//   +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//
+// Update and link cost messages carry nothing more than their sections.
+//
+// The paths section, for path vector routing, looks like:
+//
+//   +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//   |              NUMBER OF PATHS                  |
+//   +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//   |  SERVER ID  |   PATH LENGTH  |  PATH SERVER 1 | ...
+//   +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//
+// the sequence numbers section looks like:
+//
+//   +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//   |         NUMBER OF SEQUENCE NUMBERS            |
+//   +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//   |  SERVER ID  |     SEQUENCE NUMBER (4)     | ...
+//   +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//
+// the route requests section, for babel, looks like:
+//
+//   +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//   |           NUMBER OF ROUTE REQUESTS            |
+//   +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//   |  SERVER ID  |  SEQUENCE NUMBER (4) | HOP COUNT| ...
+//   +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//
+// and the metrics section, which carries link attributes in a link cost
+// update, looks like:
+//
+//   +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//   |         NUMBER OF LINK ATTRIBUTE SETS         |
+//   +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//   |  SERVER ID  | ATTRIBUTES SET |   DELAY (4)    |
+//   +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//   |     BANDWIDTH (4)    |   LOSS   | ...
+//   +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+const (
+	Magic      uint16 = 0xD7C5
+	Version    uint8  = 1
+	HeaderSize int    = 8
+)
+
+// Message types. A message in the assignment's format has no header, and
+// is a link cost update if it holds a single entry, or a routing update.
+const (
+	TypeUpdate    uint8 = 1 // Routing update
+	TypeLinkState uint8 = 2 // Routing update with link state advertisements
+	TypeLinkCost  uint8 = 3 // Link cost update, sent by the update command
+	TypeHello     uint8 = 4 // Hello
+	TypeProbe     uint8 = 5 // Link probe or its reply
+)
+
+// Header flags, saying which sections a message has, its address family
+// and whether it has a checksum
+const (
+	FlagPaths    uint16 = 1 << 0
+	FlagSeqs     uint16 = 1 << 1
+	FlagRequests uint16 = 1 << 2
+	FlagMetrics  uint16 = 1 << 3
+	FlagIPv6     uint16 = 1 << 4 // The address family, IPv6 if set
+	FlagChecksum uint16 = 1 << 5 // The message ends with a checksum
+	FlagPartial  uint16 = 1 << 6 // The update only carries the routes that changed
+
+	knownFlags = FlagPaths | FlagSeqs | FlagRequests | FlagMetrics | FlagIPv6 | FlagChecksum | FlagPartial
+)
+
+// checksumSize is the size of the checksum at the end of a message
+const checksumSize = 4
+
+// castagnoli is the CRC32C table the checksum is worked out with
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ProbeReply is the probe flag set on the reply to a probe
+const ProbeReply uint16 = 1
+
 // Message to store the unmarshaled message from the other host server
 // The minimum possible size of an update message:
 // 8 bytes for the host servers information
@@ -48,121 +175,368 @@ import (
 // With a minimum of 1 expected neighbors, the minimum size of the message
 // is expected to be 8 + 12 = 20 bytes
 type Message struct {
+	Version uint8                 // Header version, 0 for the assignment's format
+	Type    uint8                 // Message type
 	Updates uint16                // Number of expected fields
 	Port    uint16                // Port of the host server sending the msg
-	IP      string                // IP of the host server sending the msg
-	N       map[uint16]*Neighbor  // Map of the neighbor information in the hosts routng table
+	IP      net.IP                // IP of the host server sending the msg
+	N       []Neighbor            // The neighbor information in the hosts routing table, sorted by ID once unmarshaled or built with Set
+	LSAs    []LSA                 // Link state advertisements being flooded, if any
+	Requests []Request            // Route requests for the receiver, if any
+	Probe   *Probe                // Link probe, if this is one
+	Hello   *Hello                // Hello, if this is one
+	Checksum bool                 // Whether the message ends with a checksum
+	Partial bool                  // Whether the update only carries the routes that changed
+}
+
+// Entry returns the message's entry for a server, if it has one
+func (m *Message) Entry(id uint16) (*Neighbor, bool) {
+	for i := range m.N {
+		if m.N[i].ID == id {
+			return &m.N[i], true
+		}
+	}
+	return nil, false
+}
+
+// Set adds an entry to the message, in place of the entry it had for the
+// same server if there was one. New entries go in before the first entry
+// with a higher ID, so entries added with Set stay sorted.
+func (m *Message) Set(n Neighbor) {
+	if e, ok := m.Entry(n.ID); ok {
+		*e = n
+		return
+	}
+	i := 0
+	for i < len(m.N) && m.N[i].ID < n.ID {
+		i++
+	}
+	m.N = append(m.N, Neighbor{})
+	copy(m.N[i+1:], m.N[i:])
+	m.N[i] = n
+}
+
+// Sort sorts the message's entries by ID
+func (m *Message) Sort() {
+	sortEntries(m.N)
+}
+
+// sorted returns the message's entries sorted by ID, copying them only when
+// they aren't sorted already, so marshaling leaves the message as it was
+func (m *Message) sorted() []Neighbor {
+	for i := 1; i < len(m.N); i++ {
+		if m.N[i].ID < m.N[i-1].ID {
+			entries := append([]Neighbor(nil), m.N...)
+			sortEntries(entries)
+			return entries
+		}
+	}
+	return m.N
+}
+
+// sortEntries sorts entries by ID
+func sortEntries(entries []Neighbor) {
+	// Insertion sort, the entries are usually sorted already
+	for i := 1; i < len(entries); i++ {
+		for j := i; j > 0 && entries[j].ID < entries[j-1].ID; j-- {
+			entries[j], entries[j-1] = entries[j-1], entries[j]
+		}
+	}
+}
+
+// Equal checks if two messages say the same thing, so a message is equal to
+// itself after it's been marshaled and unmarshaled. The format the messages
+// were sent in, and the order of their entries, don't matter.
+func (m *Message) Equal(o *Message) bool {
+	mt, ot := m.Type, o.Type
+	if mt == 0 {
+		mt = m.kind()
+	}
+	if ot == 0 {
+		ot = o.kind()
+	}
+	if mt != ot || m.Updates != o.Updates || m.Port != o.Port || !m.IP.Equal(o.IP) || m.Partial != o.Partial {
+		return false
+	}
+
+	if len(m.N) != len(o.N) {
+		return false
+	}
+	for i := range m.N {
+		n, ok := o.Entry(m.N[i].ID)
+		if !ok || !m.N[i].Equal(n) {
+			return false
+		}
+	}
+
+	if len(m.LSAs) != len(o.LSAs) || len(m.Requests) != len(o.Requests) {
+		return false
+	}
+	for i := range m.LSAs {
+		if !m.LSAs[i].Equal(&o.LSAs[i]) {
+			return false
+		}
+	}
+	for i := range m.Requests {
+		if m.Requests[i] != o.Requests[i] {
+			return false
+		}
+	}
+
+	if (m.Probe == nil) != (o.Probe == nil) || (m.Probe != nil && *m.Probe != *o.Probe) {
+		return false
+	}
+	if (m.Hello == nil) != (o.Hello == nil) || (m.Hello != nil && *m.Hello != *o.Hello) {
+		return false
+	}
+	return true
+}
+
+// Probe is a link probe, which the receiver sends straight back with the
+// reply flag set
+type Probe struct {
+	Seq   uint32 // Sequence number of the probe
+	Sent  int64  // When the probe was sent, in unix nanoseconds
+	Flags uint16 // ProbeReply on a reply
+}
+
+// Hello tells a neighbor we're alive, and how long it should wait to hear
+// from us again before it takes us for dead
+type Hello struct {
+	Interval   uint32 // Milliseconds between the sender's hellos
+	Multiplier uint16 // Hellos that can be missed before the sender is dead
+}
+
+// Request is a route request, asking for a route to a server with at least
+// the given sequence number
+type Request struct {
+	ID   uint16 // ID of the server a route is wanted for
+	Seq  uint32 // Sequence number the route needs
+	Hops uint16 // How many more times the request can be forwarded
+}
+
+// LSA is a link state advertisement, the links of the server it originated
+// from and their costs
+type LSA struct {
+	Origin uint16 // ID of the server that originated the LSA
+	Seq    uint32 // Sequence number, higher is newer
+	Age    uint16 // Seconds since the LSA was originated
+	Links  []Link // The origin's links that are up
+}
+
+// Equal checks if two link state advertisements are the same
+func (l *LSA) Equal(o *LSA) bool {
+	if l.Origin != o.Origin || l.Seq != o.Seq || l.Age != o.Age || len(l.Links) != len(o.Links) {
+		return false
+	}
+	for i := range l.Links {
+		if l.Links[i] != o.Links[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Link is a link in a link state advertisement
+type Link struct {
+	ID   uint16 // ID of the server at the other end of the link
+	Cost uint16 // Link cost
 }
 
 // Neighbor To store the information about the host servers neighbors
 // Total size of each neighbors info is 12 bytes with null byte
 type Neighbor struct {
-	IP   string // Neighbor IP
+	IP   net.IP // Neighbor IP
 	Port uint16 // Neighbor Port
 	ID   uint16 // Neighbor ID
 	Cost uint16 // Neighbor Link cost
+	Path []uint16 // Servers the route goes through, in path vector mode
+	Seq  uint32   // Sequence number stamped by the neighbor, in sequenced mode
+	Metrics metric.Link // Link attributes, in a link cost update
 }
 
-// UnmarshalMessage unmarshals an update message into a message struct
-//
-// Unmarshaled message should like like:
-//  {nUpdates:3 hPort:2001 hIP:192.168.200.80 n:map[1:0xc00000e318 2:0xc00000e348 3:0xc00000e378]}
-//
-// With the map containing
-//  {nIP:192.168.200.80 nPort:2000 nID:1 nCost:7}
-//  {nIP:192.168.200.80 nPort:2001 nID:2 nCost:0}
-//  {nIP:192.168.200.80 nPort:2002 nID:3 nCost:2}
-func UnmarshalMessage(msg []byte, m *Message) error {
-	// Create a new map for the unmarshalled neighbors
-	var neighbors map[uint16]*Neighbor
-	neighbors = make(map[uint16]*Neighbor, 4)
-
-	// Did we actually get a message struct?
-	if m == nil {
-		return errors.Errorf("header must be non-nil")
+// Equal checks if two entries are the same
+func (n *Neighbor) Equal(o *Neighbor) bool {
+	if !n.IP.Equal(o.IP) || n.Port != o.Port || n.ID != o.ID || n.Cost != o.Cost ||
+		n.Seq != o.Seq || n.Metrics != o.Metrics || len(n.Path) != len(o.Path) {
+		return false
 	}
+	for i := range n.Path {
+		if n.Path[i] != o.Path[i] {
+			return false
+		}
+	}
+	return true
+}
 
-	// Check if the message is of the correct length
-	if len(msg) < 20 {
-		return errors.Errorf("msg does not have the expected size - %d", len(msg))
+// Marshal marshal's the update message into an array of bytes, with a
+// header in front of it. A message without a type gets the type of what
+// it carries. The entries go out sorted by ID, so the same message always
+// marshals to the same bytes, but the message itself isn't changed.
+func (m *Message) Marshal() ([]byte, error) {
+	// Create a new buffer to write the message to
+	buf := new(bytes.Buffer)
+	entries := m.sorted()
+
+	t := m.Type
+	if t == 0 {
+		t = m.kind()
 	}
+	flags := m.flags()
+
+	// The header, with the length filled in once we know it
+	binary.Write(buf, binary.BigEndian, Magic)
+	buf.WriteByte(Version)
+	buf.WriteByte(t)
+	binary.Write(buf, binary.BigEndian, flags)
+	binary.Write(buf, binary.BigEndian, uint16(0))
 
-	// The number of expected updates is formed by the first two bytes such that
-	// it results in an uint16.
-	//
-	// As this comes from the network in UDP packets we can assume that it comes
-	// in BigEndian (network byte order).
-	m.Updates = uint16(msg[1]) | uint16(msg[0])<<8
-	m.Port = uint16(msg[3]) | uint16(msg[2])<<8
-	m.IP = fmt.Sprintf("%d.%d.%d.%d\n", msg[4], msg[5], msg[6], msg[7])
+	// What the message type carries
+	switch t {
+	case TypeUpdate, TypeLinkCost:
+	case TypeLinkState:
+		marshalLSAs(buf, m.LSAs)
+	case TypeHello:
+		if m.Hello == nil {
+			return nil, errors.Errorf("hello message has no hello")
+		}
+		binary.Write(buf, binary.BigEndian, m.Hello.Interval)
+		binary.Write(buf, binary.BigEndian, m.Hello.Multiplier)
+	case TypeProbe:
+		if m.Probe == nil {
+			return nil, errors.Errorf("probe message has no probe")
+		}
+		binary.Write(buf, binary.BigEndian, m.Probe.Seq)
+		binary.Write(buf, binary.BigEndian, m.Probe.Sent)
+		binary.Write(buf, binary.BigEndian, m.Probe.Flags)
+	default:
+		return nil, errors.Errorf("unknown message type %d", t)
+	}
 
-	// Loop through the rest of the bytes in the messae and unmarshal each
-	// set of 12 bytes into a new message struct into a new neighbor struct
-	for b := 8; b <= len(msg)-12; b += 12 {
-		var n Neighbor
+	// The sections, in the order of their flags
+	if flags&FlagPaths != 0 {
+		marshalPaths(buf, entries)
+	}
+	if flags&FlagSeqs != 0 {
+		marshalSeqs(buf, entries)
+	}
+	if flags&FlagRequests != 0 {
+		marshalRequests(buf, m.Requests)
+	}
+	if flags&FlagMetrics != 0 {
+		marshalMetrics(buf, entries)
+	}
 
-		n.IP = fmt.Sprintf("%d.%d.%d.%d\n", msg[b], msg[b+1], msg[b+2], msg[b+3])
-		n.Port = uint16(msg[b+5]) | uint16(msg[b+4])<<8
-		n.ID = uint16(msg[b+9]) | uint16(msg[b+8])<<8
-		n.Cost = uint16(msg[b+11]) | uint16(msg[b+10])<<8
+	if err := m.marshalUpdate(buf, entries, flags&FlagIPv6 != 0); err != nil {
+		return nil, err
+	}
 
-		neighbors[n.ID] = &n
+	packet := buf.Bytes()
+	length := len(packet) - HeaderSize
+	if flags&FlagChecksum != 0 {
+		length += checksumSize
 	}
+	if length > math.MaxUint16 {
+		return nil, errors.Errorf("message is too long - %d bytes", HeaderSize+length)
+	}
+	binary.BigEndian.PutUint16(packet[6:], uint16(length))
 
-	// Set the message neighbors map to be the neighbors map we just initialized
-	m.N = neighbors
-	return nil
+	// The checksum covers the length, so it goes on last
+	if flags&FlagChecksum != 0 {
+		packet = append(packet, 0, 0, 0, 0)
+		sum := crc32.Checksum(packet[:len(packet)-checksumSize], castagnoli)
+		binary.BigEndian.PutUint32(packet[len(packet)-checksumSize:], sum)
+	}
+	return packet, nil
 }
 
-// Marshal marshal's the update message into an array of bytes
-func (m *Message) Marshal() ([]byte, error) {
-	// Create a new buffer to write the message to
+// MarshalLegacy marshal's the update message into an array of bytes in the
+// assignment's format, without a header. Only routing updates and link
+// cost updates that carry nothing but costs can be sent this way. Like
+// Marshal, the entries go out sorted by ID.
+func (m *Message) MarshalLegacy() ([]byte, error) {
+	t := m.Type
+	if t == 0 {
+		t = m.kind()
+	}
+	if t != TypeUpdate && t != TypeLinkCost {
+		return nil, errors.Errorf("message type %d can't be sent in the assignment's format", t)
+	}
+	// Its addresses can only be IPv4, which marshalUpdate checks
+	if flags := m.flags() &^ FlagIPv6; flags != 0 {
+		return nil, errors.Errorf("message with flags %#x can't be sent in the assignment's format", flags)
+	}
+
 	buf := new(bytes.Buffer)
+	if err := m.marshalUpdate(buf, m.sorted(), false); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// kind returns the type of a message from what it carries
+func (m *Message) kind() uint8 {
+	switch {
+	case m.Hello != nil:
+		return TypeHello
+	case m.Probe != nil:
+		return TypeProbe
+	case len(m.LSAs) > 0:
+		return TypeLinkState
+	}
+	return TypeUpdate
+}
+
+// flags returns the header flags for the sections, address family,
+// checksum and partial update a message needs
+func (m *Message) flags() uint16 {
+	var flags uint16
+	if m.hasPaths() {
+		flags |= FlagPaths
+	}
+	if m.hasSeqs() {
+		flags |= FlagSeqs
+	}
+	if len(m.Requests) > 0 {
+		flags |= FlagRequests
+	}
+	if m.hasMetrics() {
+		flags |= FlagMetrics
+	}
+	if m.hasIPv6() {
+		flags |= FlagIPv6
+	}
+	if m.Checksum {
+		flags |= FlagChecksum
+	}
+	if m.Partial {
+		flags |= FlagPartial
+	}
+	return flags
+}
+
+// marshalUpdate writes the update message, in the assignment's format,
+// into the buffer, with the given entries in place of the message's and
+// IPv6 addresses if ipv6 is set
+func (m *Message) marshalUpdate(buf *bytes.Buffer, entries []Neighbor, ipv6 bool) error {
+	// The count has to match the entries, or the message can't be decoded
+	if int(m.Updates) != len(entries) {
+		return errors.Wrapf(ErrCountMismatch, "message has %d entries, its count says %d", len(entries), m.Updates)
+	}
 
 	// Write the number of updates and the host port number
 	// into the buffer, encoded as binary using Big Endian
 	binary.Write(buf, binary.BigEndian, m.Updates)
 	binary.Write(buf, binary.BigEndian, m.Port)
-
-	// Split up the IP address
-	iparr := strings.Split(m.IP, ".")
-
-	// Create new 8 bit unsigned integer for each part of the IP address
-	ip064, _ := strconv.ParseUint(iparr[0], 10, 8)
-	ip0 := uint8(ip064)
-	ip164, _ := strconv.ParseUint(iparr[1], 10, 8)
-	ip1 := uint8(ip164)
-	ip264, _ := strconv.ParseUint(iparr[2], 10, 8)
-	ip2 := uint8(ip264)
-	ip364, _ := strconv.ParseUint(iparr[3], 10, 8)
-	ip3 := uint8(ip364)
-
-	// Write each part of the IP address to the buffer
-	buf.WriteByte(ip0)
-	buf.WriteByte(ip1)
-	buf.WriteByte(ip2)
-	buf.WriteByte(ip3)
+	if err := marshalAddr(buf, m.IP, ipv6); err != nil {
+		return errors.Wrapf(err, "bad sender address")
+	}
 
 	// For each neighbor in our message -
-	for _, n := range m.N {
-		// Split up the neighbors IP address
-		iparr := strings.Split(n.IP, ".")
-
-		// Create new 8 bit unsigned integer for each part of the IP address
-		ip064, _ := strconv.ParseUint(iparr[0], 10, 8)
-		ip0 := uint8(ip064)
-		ip164, _ := strconv.ParseUint(iparr[1], 10, 8)
-		ip1 := uint8(ip164)
-		ip264, _ := strconv.ParseUint(iparr[2], 10, 8)
-		ip2 := uint8(ip264)
-		ip364, _ := strconv.ParseUint(iparr[3], 10, 8)
-		ip3 := uint8(ip364)
-
-		// Write each part of the IP address to the buffer
-		buf.WriteByte(ip0)
-		buf.WriteByte(ip1)
-		buf.WriteByte(ip2)
-		buf.WriteByte(ip3)
+	for _, n := range entries {
+		if err := marshalAddr(buf, n.IP, ipv6); err != nil {
+			return errors.Wrapf(err, "bad address for server %d", n.ID)
+		}
 
 		// Write the neighbors port number into the buffer,
 		// encoded as binary using Big Endian
@@ -177,7 +551,127 @@ func (m *Message) Marshal() ([]byte, error) {
 		binary.Write(buf, binary.BigEndian, n.ID)
 		binary.Write(buf, binary.BigEndian, n.Cost)
 	}
+	return nil
+}
 
-	// Return the bytes writen to the buffer
-	return buf.Bytes(), nil
+// marshalAddr writes an address into the buffer, as an IPv6 address if
+// ipv6 is set, or an IPv4 address
+func marshalAddr(buf *bytes.Buffer, ip net.IP, ipv6 bool) error {
+	if ipv6 {
+		ip16 := ip.To16()
+		if ip16 == nil {
+			return errors.Errorf("%q is not an IP address", ip.String())
+		}
+		buf.Write(ip16)
+		return nil
+	}
+
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return errors.Errorf("%q is not an IPv4 address", ip.String())
+	}
+	buf.Write(ip4)
+	return nil
+}
+
+// hasIPv6 checks if any of the message's addresses is an IPv6 address, so
+// the message needs the IPv6 address family
+func (m *Message) hasIPv6() bool {
+	if len(m.IP) == net.IPv6len && m.IP.To4() == nil {
+		return true
+	}
+	for _, n := range m.N {
+		if len(n.IP) == net.IPv6len && n.IP.To4() == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// marshalLSAs writes link state advertisements into the buffer
+func marshalLSAs(buf *bytes.Buffer, lsas []LSA) {
+	binary.Write(buf, binary.BigEndian, uint16(len(lsas)))
+	for _, l := range lsas {
+		binary.Write(buf, binary.BigEndian, l.Origin)
+		binary.Write(buf, binary.BigEndian, l.Seq)
+		binary.Write(buf, binary.BigEndian, l.Age)
+		binary.Write(buf, binary.BigEndian, uint16(len(l.Links)))
+		for _, link := range l.Links {
+			binary.Write(buf, binary.BigEndian, link.ID)
+			binary.Write(buf, binary.BigEndian, link.Cost)
+		}
+	}
+}
+
+// hasPaths checks if any of the message's neighbors has a path
+func (m *Message) hasPaths() bool {
+	for _, n := range m.N {
+		if len(n.Path) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// marshalPaths writes the path of each neighbor into the buffer
+func marshalPaths(buf *bytes.Buffer, neighbors []Neighbor) {
+	binary.Write(buf, binary.BigEndian, uint16(len(neighbors)))
+	for _, n := range neighbors {
+		binary.Write(buf, binary.BigEndian, n.ID)
+		binary.Write(buf, binary.BigEndian, uint16(len(n.Path)))
+		for _, id := range n.Path {
+			binary.Write(buf, binary.BigEndian, id)
+		}
+	}
+}
+
+// hasSeqs checks if any of the message's neighbors has a sequence number
+func (m *Message) hasSeqs() bool {
+	for _, n := range m.N {
+		if n.Seq != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// marshalSeqs writes the sequence number of each neighbor into the buffer
+func marshalSeqs(buf *bytes.Buffer, neighbors []Neighbor) {
+	binary.Write(buf, binary.BigEndian, uint16(len(neighbors)))
+	for _, n := range neighbors {
+		binary.Write(buf, binary.BigEndian, n.ID)
+		binary.Write(buf, binary.BigEndian, n.Seq)
+	}
+}
+
+// marshalRequests writes route requests into the buffer
+func marshalRequests(buf *bytes.Buffer, reqs []Request) {
+	binary.Write(buf, binary.BigEndian, uint16(len(reqs)))
+	for _, r := range reqs {
+		binary.Write(buf, binary.BigEndian, r.ID)
+		binary.Write(buf, binary.BigEndian, r.Seq)
+		binary.Write(buf, binary.BigEndian, r.Hops)
+	}
+}
+
+// hasMetrics checks if any of the message's neighbors has link attributes
+func (m *Message) hasMetrics() bool {
+	for _, n := range m.N {
+		if n.Metrics.Set != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// marshalMetrics writes the link attributes of each neighbor into the buffer
+func marshalMetrics(buf *bytes.Buffer, neighbors []Neighbor) {
+	binary.Write(buf, binary.BigEndian, uint16(len(neighbors)))
+	for _, n := range neighbors {
+		binary.Write(buf, binary.BigEndian, n.ID)
+		binary.Write(buf, binary.BigEndian, uint16(n.Metrics.Set))
+		binary.Write(buf, binary.BigEndian, uint32(n.Metrics.Delay))
+		binary.Write(buf, binary.BigEndian, uint32(n.Metrics.Bandwidth))
+		binary.Write(buf, binary.BigEndian, uint16(n.Metrics.Loss))
+	}
 }
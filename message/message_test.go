@@ -0,0 +1,167 @@
+package message
+
+import (
+	"bytes"
+	"dvr/metric"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// entry returns an entry for a server at 10.0.0.<id>:<2000+id>
+func entry(id, cost uint16) Neighbor {
+	return Neighbor{IP: net.IPv4(10, 0, 0, byte(id)), Port: 2000 + id, ID: id, Cost: cost}
+}
+
+func TestRoundTrip(t *testing.T) {
+	paths := []Neighbor{entry(1, 0), entry(3, 2), entry(2, 5)}
+	paths[0].Path = []uint16{1}
+	paths[1].Path = []uint16{1, 3}
+	paths[2].Path = []uint16{1, 3, 2}
+
+	seqs := []Neighbor{entry(2, 1), entry(1, 0)}
+	seqs[0].Seq = 7
+	seqs[1].Seq = 42
+
+	metrics := []Neighbor{entry(2, 30)}
+	metrics[0].Metrics = metric.Link{Delay: 20, Bandwidth: 100, Set: metric.Delay | metric.Bandwidth}
+
+	ipv6 := []Neighbor{entry(1, 0), {IP: net.ParseIP("::1"), Port: 2002, ID: 2, Cost: 4}}
+
+	tests := []struct {
+		name string
+		msg  Message
+	}{
+		{"update", Message{Updates: 3, N: []Neighbor{entry(1, 0), entry(2, 7), entry(3, 4)}}},
+		{"link cost", Message{Type: TypeLinkCost, Updates: 1, N: []Neighbor{entry(2, 8)}}},
+		{"paths", Message{Updates: 3, N: paths}},
+		{"sequence numbers", Message{Updates: 2, N: seqs}},
+		{"metrics", Message{Type: TypeLinkCost, Updates: 1, N: metrics}},
+		{"requests", Message{Updates: 1, N: []Neighbor{entry(1, 0)}, Requests: []Request{{ID: 3, Seq: 9, Hops: 4}}}},
+		{"link state", Message{Updates: 1, N: []Neighbor{entry(1, 0)}, LSAs: []LSA{
+			{Origin: 1, Seq: 3, Age: 10, Links: []Link{{ID: 2, Cost: 7}, {ID: 3, Cost: 4}}},
+			{Origin: 2, Seq: 1, Links: []Link{{ID: 1, Cost: 7}}},
+		}}},
+		{"hello", Message{Updates: 1, N: []Neighbor{entry(1, 0)}, Hello: &Hello{Interval: 500, Multiplier: 3}}},
+		{"probe", Message{Updates: 1, N: []Neighbor{entry(1, 0)}, Probe: &Probe{Seq: 5, Sent: 1234567890, Flags: ProbeReply}}},
+		{"ipv6", Message{Updates: 2, N: ipv6}},
+		{"checksum", Message{Updates: 2, N: []Neighbor{entry(2, 3), entry(1, 0)}, Checksum: true}},
+		{"partial", Message{Updates: 2, N: []Neighbor{entry(1, 0), entry(2, 3)}, Partial: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := tt.msg
+			m.Port = 2001
+			m.IP = net.IPv4(10, 0, 0, 1)
+
+			packet, err := m.Marshal()
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			var got Message
+			if err := UnmarshalMessage(packet, &got); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if !got.Equal(&m) {
+				t.Errorf("round trip gave %+v, want %+v", got, m)
+			}
+		})
+	}
+}
+
+func TestRoundTripLegacy(t *testing.T) {
+	m := Message{Updates: 3, Port: 2001, IP: net.IPv4(10, 0, 0, 1), N: []Neighbor{entry(3, 4), entry(1, 0), entry(2, 7)}}
+	packet, err := m.MarshalLegacy()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var got Message
+	if err := UnmarshalMessage(packet, &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if !got.Equal(&m) || got.Version != 0 {
+		t.Errorf("round trip gave %+v, want %+v", got, m)
+	}
+}
+
+func TestMarshalSorted(t *testing.T) {
+	// Entries go out sorted by ID whatever order they're in, and the
+	// message itself is left as it was
+	m := Message{Updates: 4, Port: 2001, IP: net.IPv4(10, 0, 0, 1), N: []Neighbor{entry(4, 5), entry(1, 0), entry(3, 4), entry(2, 7)}}
+	sorted := Message{Updates: 4, Port: 2001, IP: net.IPv4(10, 0, 0, 1), N: []Neighbor{entry(1, 0), entry(2, 7), entry(3, 4), entry(4, 5)}}
+
+	packet, err := m.Marshal()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	want, err := sorted.Marshal()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if !bytes.Equal(packet, want) {
+		t.Errorf("marshal = % x, want % x", packet, want)
+	}
+
+	// The IDs on the wire, after the header and the sender's fields
+	for i, id := range []uint16{1, 2, 3, 4} {
+		off := HeaderSize + updateSize + net.IPv4len + i*(net.IPv4len+entrySize) + net.IPv4len + 4
+		if got := binary.BigEndian.Uint16(packet[off:]); got != id {
+			t.Errorf("entry %d on the wire is server %d, want %d", i, got, id)
+		}
+	}
+
+	for i, id := range []uint16{4, 1, 3, 2} {
+		if m.N[i].ID != id {
+			t.Fatalf("marshal reordered the message's entries to %+v", m.N)
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	var m Message
+	for _, id := range []uint16{3, 1, 4, 2} {
+		m.Set(entry(id, id))
+	}
+	m.Set(entry(3, 9))
+
+	if len(m.N) != 4 {
+		t.Fatalf("message has %d entries, want 4", len(m.N))
+	}
+	for i, n := range m.N {
+		if n.ID != uint16(i+1) {
+			t.Errorf("entry %d is server %d, want %d", i, n.ID, i+1)
+		}
+	}
+	if n, _ := m.Entry(3); n.Cost != 9 {
+		t.Errorf("server 3's cost is %d, want 9", n.Cost)
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  Message
+	}{
+		{"count", Message{Updates: 2, IP: net.IPv4(10, 0, 0, 1), N: []Neighbor{entry(1, 0)}}},
+		{"address", Message{Updates: 1, N: []Neighbor{entry(1, 0)}}},
+		{"hello", Message{Type: TypeHello, Updates: 1, IP: net.IPv4(10, 0, 0, 1), N: []Neighbor{entry(1, 0)}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.msg.Marshal(); err == nil {
+				t.Errorf("marshal of %+v succeeded", tt.msg)
+			}
+		})
+	}
+
+	// The assignment's format has no room for a checksum, IPv6 or a
+	// partial update
+	for _, m := range []Message{
+		{Updates: 1, IP: net.IPv4(10, 0, 0, 1), N: []Neighbor{entry(1, 0)}, Checksum: true},
+		{Updates: 1, IP: net.ParseIP("::1"), N: []Neighbor{entry(1, 0)}},
+	} {
+		if _, err := m.MarshalLegacy(); err == nil {
+			t.Errorf("legacy marshal of %+v succeeded", m)
+		}
+	}
+}
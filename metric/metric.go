@@ -0,0 +1,177 @@
+// Package metric provides link attributes and the composite cost worked
+// out from them
+package metric
+
+import (
+    "strconv"
+    "strings"
+
+    "github.com/pkg/errors"
+)
+
+// Attr is a link attribute, used as a bit in Link.Set
+type Attr uint8
+
+const (
+    // Delay is the one way delay of a link, in milliseconds
+    Delay Attr = 1 << iota
+    // Bandwidth is the bandwidth of a link, in Mbps
+    Bandwidth
+    // Loss is the packet loss of a link, in percent
+    Loss
+)
+
+// names maps each attribute's name, as given to the update command and in
+// topology files, to the attribute
+var names = map[string]Attr{
+    "delay": Delay,
+    "bw": Bandwidth,
+    "loss": Loss,
+}
+
+// Link holds the attributes of a link. Only the attributes in Set have
+// been given.
+type Link struct {
+    Delay int
+    Bandwidth int
+    Loss int
+    Set Attr
+}
+
+// Weights are how much each attribute counts towards the composite cost,
+// and the bandwidth that counts as 1
+type Weights struct {
+    Delay int
+    Bandwidth int
+    Loss int
+    RefBandwidth int
+}
+
+// DefaultWeights counts every attribute the same, with a 1000 Mbps
+// reference bandwidth
+var DefaultWeights = Weights{Delay: 1, Bandwidth: 1, Loss: 1, RefBandwidth: 1000}
+
+// IsAttr checks if an argument looks like an attribute, such as delay=20
+func IsAttr(arg string) bool {
+    return strings.Contains(arg, "=")
+}
+
+// Parse parses link attributes like delay=20 bw=100 loss=1
+func Parse(args []string) (Link, error) {
+    var l Link
+    for _, arg := range args {
+        kv := strings.SplitN(arg, "=", 2)
+        if len(kv) != 2 {
+            return l, errors.Errorf("link attribute '%s' must look like name=value", arg)
+        }
+        attr, ok := names[kv[0]]
+        if !ok {
+            return l, errors.Errorf("unknown link attribute '%s', must be one of delay, bw or loss", kv[0])
+        }
+        v, err := strconv.Atoi(kv[1])
+        if err != nil || v < 0 {
+            return l, errors.Errorf("link attribute '%s' must be a non negative integer", kv[0])
+        }
+
+        switch attr {
+        case Delay:
+            l.Delay = v
+        case Bandwidth:
+            if v == 0 {
+                return l, errors.Errorf("link attribute 'bw' must be above 0")
+            }
+            l.Bandwidth = v
+        case Loss:
+            if v > 100 {
+                return l, errors.Errorf("link attribute 'loss' must be a percentage")
+            }
+            l.Loss = v
+        }
+        l.Set |= attr
+    }
+    return l, nil
+}
+
+// ParseWeights parses attribute weights like delay=1,bw=2,loss=10,ref=1000.
+// Attributes that aren't given keep their default weight.
+func ParseWeights(s string) (Weights, error) {
+    w := DefaultWeights
+    for _, arg := range strings.Split(s, ",") {
+        kv := strings.SplitN(arg, "=", 2)
+        if len(kv) != 2 {
+            return w, errors.Errorf("weight '%s' must look like name=value", arg)
+        }
+        v, err := strconv.Atoi(kv[1])
+        if err != nil || v < 0 {
+            return w, errors.Errorf("weight '%s' must be a non negative integer", kv[0])
+        }
+
+        switch kv[0] {
+        case "delay":
+            w.Delay = v
+        case "bw":
+            w.Bandwidth = v
+        case "loss":
+            w.Loss = v
+        case "ref":
+            if v == 0 {
+                return w, errors.Errorf("reference bandwidth must be above 0")
+            }
+            w.RefBandwidth = v
+        default:
+            return w, errors.Errorf("unknown weight '%s', must be one of delay, bw, loss or ref", kv[0])
+        }
+    }
+    return w, nil
+}
+
+// Merge returns the link with the attributes set in with replacing its own
+func (l Link) Merge(with Link) Link {
+    if with.Set&Delay != 0 {
+        l.Delay = with.Delay
+    }
+    if with.Set&Bandwidth != 0 {
+        l.Bandwidth = with.Bandwidth
+    }
+    if with.Set&Loss != 0 {
+        l.Loss = with.Loss
+    }
+    l.Set |= with.Set
+    return l
+}
+
+// Cost works out the composite cost of a link from its attributes, like
+// EIGRP: the weighted sum of the delay, the reference bandwidth over the
+// bandwidth and the loss. Attributes that aren't set count as 0, and the
+// cost is at least 1.
+func (w Weights) Cost(l Link) int {
+    cost := 0
+    if l.Set&Delay != 0 {
+        cost += w.Delay * l.Delay
+    }
+    if l.Set&Bandwidth != 0 {
+        cost += w.Bandwidth * w.RefBandwidth / l.Bandwidth
+    }
+    if l.Set&Loss != 0 {
+        cost += w.Loss * l.Loss
+    }
+    if cost < 1 {
+        cost = 1
+    }
+    return cost
+}
+
+// String formats the attributes that are set, like delay=20 bw=100
+func (l Link) String() string {
+    attrs := make([]string, 0, 3)
+    if l.Set&Delay != 0 {
+        attrs = append(attrs, "delay=" + strconv.Itoa(l.Delay))
+    }
+    if l.Set&Bandwidth != 0 {
+        attrs = append(attrs, "bw=" + strconv.Itoa(l.Bandwidth))
+    }
+    if l.Set&Loss != 0 {
+        attrs = append(attrs, "loss=" + strconv.Itoa(l.Loss))
+    }
+    return strings.Join(attrs, " ")
+}
@@ -0,0 +1,39 @@
+package network
+
+import (
+    "net"
+)
+
+// checksumFailed counts a message that failed its checksum against the
+// neighbor it came from. Messages from addresses that aren't a neighbor's
+// listener, like servers sending from another port, are counted as server 0.
+func (r *Router) checksumFailed(from *net.UDPAddr) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.checksumFailures[r.neighborAt(from)]++
+}
+
+// neighborAt returns the ID of the neighbor bound to an address, or 0 if
+// there isn't one. The router's lock must be held.
+func (r *Router) neighborAt(addr *net.UDPAddr) uint16 {
+    if addr == nil {
+        return 0
+    }
+    for id, server := range r.table {
+        if id != r.ID && server.port == addr.Port && net.ParseIP(server.IP).Equal(addr.IP) {
+            return id
+        }
+    }
+    return 0
+}
+
+// ChecksumFailures returns how many messages from each neighbor failed their
+// checksum since the last time this function was called
+func (r *Router) ChecksumFailures() map[uint16]int {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    failures := r.checksumFailures
+    r.checksumFailures = make(map[uint16]int)
+    return failures
+}
@@ -1,168 +1,254 @@
 package network
 
-import "github.com/pkg/errors"
+import (
+    "dvr/metric"
+    "dvr/types"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/pkg/errors"
+)
 
 // DisplayTable displays the routers table
 func (r *Router) DisplayTable() {
     r.mu.Lock()
     defer r.mu.Unlock()
 
+    table := r.engine.Table()
+    dw, hw := tableColumns(table)
+
+    // Routes that carry their path are displayed with the whole path
+    // instead of only the next hop
+    paths := false
+    for _, rt := range table {
+        if len(rt.Path) > 0 {
+            paths = true
+        }
+    }
+
     // Display the table headers
-    r.log.OutServer("\ndst | next hop | cost\n")
-    r.log.OutServer("----+----------+-------\n")
+    if paths {
+        r.log.OutServer("\n %-*s | cost  | path\n", dw, "dst")
+        r.log.OutServer(rule(dw, 5, 16))
+    } else {
+        r.log.OutServer("\n %-*s | %-*s | cost\n", dw, "dst", hw, "next hop")
+        r.log.OutServer(rule(dw, hw, 5))
+    }
 
     // Display each active server's ID, nextHop & link cost
-    var i uint16 = 1
-    for ; i <= uint16(NumServers); i++ {
-        if r.table[i].linkCost == Inf || r.table[i].linkCost == 0 || r.table[i].nextHop == 0 {
+    for _, rt := range table {
+        if rt.ID == r.ID || !r.reachable(rt.Cost) || rt.NextHop == 0 {
             continue
         }
-        r.log.OutServer(" %d  |\t  %d    |  %d\n", r.table[i].ID, r.table[i].nextHop, r.table[i].linkCost)
+        if paths {
+            r.log.OutServer(" %-*d | %-5d | %s\n", dw, rt.ID, rt.Cost, r.formatPath(rt.Path))
+            continue
+        }
+        r.log.OutServer(" %-*d | %-*s | %d\n", dw, rt.ID, hw, formatHops(rt), rt.Cost)
     }
 }
 
-// Update updates the link cost between to servers
-func (r *Router) Update(id1, id2 uint16, newCost int) error {
+// DisplayState displays every entry in the routers table, along with
+// where its route is in its lifecycle and how long until that changes
+func (r *Router) DisplayState() {
     r.mu.Lock()
+    defer r.mu.Unlock()
 
-    sid := r.ID
-    var rt routingTable
+    table := r.engine.Table()
+    dw, hw := tableColumns(table)
 
-    // Is one of the IDs to update our router?
-    if id1 == sid || id2 == sid {
-        // Yep, let's make an update table so we can perform
-        // a table update
-        tableUp := make(map[uint16]tableUpdate, len(r.table))
-        for id, server := range r.table {
-            if id == id1 && id1 != sid {
-                r.table[id].directCost = newCost
-                r.table[id].linkCost = newCost
-                t := tableUpdate{
-                    ID: server.ID,
-                    Cost: newCost,
-                }
-                tableUp[id] = t
-                continue
-            }
-            if id == id2 && id2 != sid {
-                r.table[id].directCost = newCost
-                r.table[id].linkCost = newCost
-                t := tableUpdate{
-                    ID: server.ID,
-                    Cost: newCost,
-                }
-                tableUp[id] = t
-                continue
-            }
-            t := tableUpdate{
-                ID: server.ID,
-                Cost: server.linkCost,
-            }
-            tableUp[id] = t
+    // Routes stamped with sequence numbers are displayed with them
+    seqs := false
+    for _, rt := range table {
+        if rt.Seq != 0 {
+            seqs = true
         }
+    }
 
-        rt.ID = sid
-        rt.Table = tableUp
+    // Display the table headers
+    if seqs {
+        r.log.OutServer("\n %-*s | %-*s | cost  | state   | timer | seq\n", dw, "dst", hw, "next hop")
+        r.log.OutServer(rule(dw, hw, 5, 7, 5, 5))
+    } else {
+        r.log.OutServer("\n %-*s | %-*s | cost  | state   | timer\n", dw, "dst", hw, "next hop")
+        r.log.OutServer(rule(dw, hw, 5, 7, 5))
     }
-    r.mu.Unlock()
 
-    // We need to let the affected servers know of the changes
-    // so we're going to create a new packet and make the sender the
-    // other server that was updated.
-    if id1 != sid {
-        packet, err := r.createDifferentSenderPacket(id2, id1, newCost)
-        if err != nil {
-            return errors.Wrapf(err, "r.Update: failed to perform update, couldn't create sender packet")
+    for _, rt := range table {
+        if rt.ID == r.ID {
+            continue
+        }
+
+        timer := "-"
+        if rt.Timer > 0 {
+            timer = fmt.Sprintf("%ds", int(rt.Timer.Round(time.Second).Seconds()))
+        }
+        cost := fmt.Sprintf("%d", rt.Cost)
+        if !r.reachable(rt.Cost) {
+            cost = "inf"
         }
-        err = r.SendPacket(packet, id2, id1)
-        if err != nil {
-            return errors.Wrapf(err, "r.Update: failed to perform update, couldn't send the packet to other server ")
+        if seqs {
+            r.log.OutServer(" %-*d | %-*s | %-5s | %-7s | %-5s | %d\n", dw, rt.ID, hw, formatHops(rt), cost, rt.State, timer, rt.Seq)
+            continue
         }
+        r.log.OutServer(" %-*d | %-*s | %-5s | %-7s | %s\n", dw, rt.ID, hw, formatHops(rt), cost, rt.State, timer)
     }
+}
 
-    if id2 != sid {
-        packet, err := r.createDifferentSenderPacket(id1, id2, newCost)
-        if err != nil {
-            return errors.Wrapf(err, "r.Update: failed to perform update, couldn't create sender packet")
+// tableColumns returns the widths of the destination and next hop columns,
+// wide enough for their headers and the longest ID or next hops in the table
+func tableColumns(table []types.Route) (int, int) {
+    dw, hw := len("dst"), len("next hop")
+    for _, rt := range table {
+        if w := len(strconv.Itoa(int(rt.ID))); w > dw {
+            dw = w
         }
-        err = r.SendPacket(packet, id1, id2)
-        if err != nil {
-            return errors.Wrapf(err, "r.Update: failed to perform update, couldn't send the packet to other server ")
+        if w := len(formatHops(rt)); w > hw {
+            hw = w
         }
     }
+    return dw, hw
+}
+
+// rule returns the line under the headers of a table with columns of the
+// given widths
+func rule(widths ...int) string {
+    cols := make([]string, len(widths))
+    for i, w := range widths {
+        cols[i] = strings.Repeat("-", w + 2)
+    }
+    return strings.Join(cols, "+") + "\n"
+}
+
+// Update updates the link cost between to servers
+func (r *Router) Update(id1, id2 uint16, newCost int) error {
+    // Anything at or above our infinity means the link is down
+    newCost = r.metric(newCost)
+    sid := r.ID
+
+    // We need to let the affected servers know of the changes
+    // so we're going to create a new packet and make the sender the
+    // other server that was updated. This is done before we change our
+    // own link, so that the packet can still use it.
+    var sendErr error
+    if id1 != sid {
+        sendErr = r.sendLinkUpdate(id2, id1, newCost, metric.Link{})
+    }
+    if id2 != sid && sendErr == nil {
+        sendErr = r.sendLinkUpdate(id1, id2, newCost, metric.Link{})
+    }
+
+    // Is one of the IDs to update our router?
+    if id1 == sid {
+        r.setLinkCost(id2, newCost)
+    }
+    if id2 == sid {
+        r.setLinkCost(id1, newCost)
+    }
+
+    return sendErr
+}
+
+// UpdateMetrics updates some of the attributes of the link between two
+// servers, which then use the composite cost of the link's attributes
+func (r *Router) UpdateMetrics(id1, id2 uint16, m metric.Link) error {
+    sid := r.ID
+
+    // Each end merges the attributes into the ones it has for the link,
+    // so the cost in the packet is only for servers that don't know
+    // about attributes
+    newCost := r.weights.Cost(m)
+
+    // A cost at or above infinity would take the link down, which is what
+    // disable or an update to 'inf' are for
+    cost := newCost
+    if id1 == sid {
+        cost = r.metricsCost(id2, m)
+    }
+    if id2 == sid {
+        cost = r.metricsCost(id1, m)
+    }
+    if newCost > cost {
+        cost = newCost
+    }
+    if cost >= r.inf {
+        return errors.Wrapf(MetricCostErr, "r.UpdateMetrics: cost %d with infinity %d", cost, r.inf)
+    }
+
+    var sendErr error
+    if id1 != sid {
+        sendErr = r.sendLinkUpdate(id2, id1, newCost, m)
+    }
+    if id2 != sid && sendErr == nil {
+        sendErr = r.sendLinkUpdate(id1, id2, newCost, m)
+    }
+
+    if id1 == sid {
+        r.setLinkMetrics(id2, m)
+    }
+    if id2 == sid {
+        r.setLinkMetrics(id1, m)
+    }
+
+    return sendErr
+}
+
+// metricsCost works out the cost our link to a neighbor would have once the
+// attributes are merged into the ones it has
+func (r *Router) metricsCost(id uint16, m metric.Link) int {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
 
-    if rt.Table != nil {
-        go r.UpdateTable(rt)
+    if server, ok := r.table[id]; ok {
+        m = server.metrics.Merge(m)
     }
+    return r.weights.Cost(m)
+}
 
+// sendLinkUpdate sends a link cost update to dst, on behalf of src, along
+// with the link's attributes if there are any
+func (r *Router) sendLinkUpdate(src, dst uint16, newCost int, m metric.Link) error {
+    packet, err := r.createDifferentSenderPacket(src, dst, newCost, m)
+    if err != nil {
+        return errors.Wrapf(err, "r.Update: failed to perform update, couldn't create sender packet")
+    }
+    err = r.SendPacket(packet, src, dst)
+    if err != nil {
+        return errors.Wrapf(err, "r.Update: failed to perform update, couldn't send the packet to other server ")
+    }
     return nil
 }
 
 // Disable disables a link between two routers
 func (r *Router) Disable(id uint16) error {
     r.mu.Lock()
-    defer r.mu.Unlock()
 
     if id == r.ID {
+        r.mu.Unlock()
         return errors.Wrapf(DisSErr, "r.Disable: failed to disable link")
     }
 
-    if r.table[id].directCost == Inf {
+    server, ok := r.table[id]
+    if !ok || !r.reachable(server.directCost) {
+        r.mu.Unlock()
         return errors.Wrapf(DisErr, "r.Disable: failed to disable link")
     }
 
-    // Reset the nexthop values for any servers that have the
-    // disabled server as their next hop
-    if r.table[id].directCost != r.table[id].linkCost {
-        n := r.table[id].nextHop
-        if n != id {
-            r.table[n].linkCost = r.table[n].directCost
-            r.table[n].nextHop = n
-        }
-    }
-
-    // Set the direct and link costs
-    r.table[id].directCost = Inf
-    r.table[id].linkCost = Inf
-
-    // Create a new table update to send to the update channel
-    tableUp := make(map[uint16]tableUpdate, len(r.table))
-    for i, server := range r.table {
-        if server.nextHop == id {
-            r.table[i].linkCost = r.table[i].directCost
-            r.table[i].nextHop = i
-            t := tableUpdate{
-                ID: server.ID,
-                Cost: server.directCost,
-            }
-            tableUp[id] = t
-            continue
-        }
-        if server.ID == id {
-            r.table[i].directCost = Inf
-            r.table[i].linkCost = Inf
-            r.table[i].nextHop = uint16(Inf)
-            t := tableUpdate{
-                ID: server.ID,
-                Cost: Inf,
-            }
-            tableUp[id] = t
-            continue
-        }
-        r.table[i].linkCost = r.table[i].directCost
-        r.table[i].nextHop = i
-        t := tableUpdate{
-            ID: server.ID,
-            Cost: server.directCost,
-        }
-        tableUp[id] = t
-    }
-
-    rt := routingTable{
-        ID: r.ID,
-        Table: tableUp,
-    }
-    r.UpdateChan <- rt
-
+    // Close the link and forget everything the neighbor told us, any
+    // routes that went through it are recomputed without it
+    server.directCost = r.inf
+    r.apply(func() bool {
+        return r.engine.SetLinkCost(id, r.inf)
+    })
+    r.mu.Unlock()
     return nil
 }
+
+// Stop stops the router from sending any more updates
+func (r *Router) Stop() {
+    r.mu.Lock()
+    r.stopped = true
+    r.mu.Unlock()
+}
@@ -1,36 +1,136 @@
 package network
 
 import (
+    "dvr/dv"
     "dvr/log"
+    "dvr/ls"
+    "dvr/message"
     "dvr/topology"
     "dvr/server"
+    "dvr/types"
+    "math/rand"
+    "net"
     "time"
 )
 
-var (
-    // NumServers is the number of servers in the network
-    NumServers int
-    // Inf is the inifinity link cost value
-    Inf int = 99999
-)
+// DefaultInfinity is the infinity metric used when neither the topology
+// file nor the startup flags give one
+const DefaultInfinity int = 16
+
+// ProbeInfinity is the default infinity metric when probing, so measured
+// costs have room to tell links apart and add up over a few hops
+const ProbeInfinity int = 1024
 
-// New initializes and returns a new network.
-func New(top *topology.Topology, sid uint16, l *log.Logger) *server.Server {
-    var n Network
-    NumServers = top.NumServers
-    n.Channels = make(map[uint16]chan routingTable, NumServers)
-    s := n.parseTopology(top, sid, l)
+// New initializes the router for our server and returns the server.
+func New(top *topology.Topology, sid uint16, cfg Config, l *log.Logger) *server.Server {
+    // A startup flag takes priority over the topology file
+    inf := cfg.Infinity
+    if inf == 0 {
+        inf = top.Infinity
+    }
+    if inf == 0 {
+        inf = DefaultInfinity
+        if cfg.ProbeInterval > 0 {
+            inf = ProbeInfinity
+        }
+    }
+    s := parseTopology(top, sid, inf, cfg, l)
     return s
 }
 
-// parseTopology will parse the topology configuration and create
-// the necessary routers and server
-func (n *Network) parseTopology(top *topology.Topology, sid uint16, l *log.Logger) *server.Server {
-    var routers map[uint16]*Router
-    routers = make(map[uint16]*Router, NumServers)
+// ParseEngine returns the routing engine with the given name
+func ParseEngine(name string) (Engine, error) {
+    switch name {
+    case "dv":
+        return EngineDV, nil
+    case "ls":
+        return EngineLS, nil
+    case "pv":
+        return EnginePV, nil
+    case "dsdv":
+        return EngineDSDV, nil
+    case "babel":
+        return EngineBabel, nil
+    }
+    return EngineDV, EngineErr
+}
+
+// CheckInfinity checks that an infinity metric can be carried in a message
+func CheckInfinity(metric int) error {
+    if metric < 1 || metric > message.MaxCost {
+        return InfErr
+    }
+    return nil
+}
+
+// CheckLegacy checks that the startup options and the topology only need
+// what the assignment's message format can carry
+func CheckLegacy(top *topology.Topology, cfg Config) error {
+    if !cfg.Legacy {
+        return nil
+    }
+    if cfg.Engine != EngineDV || cfg.ProbeInterval > 0 || cfg.HelloInterval > 0 || cfg.Discovery || cfg.Checksum {
+        return LegacyErr
+    }
+    for _, s := range top.Servers {
+        if net.ParseIP(s.IP).To4() == nil {
+            return LegacyErr
+        }
+    }
+    return nil
+}
 
+// parseTopology will parse the topology configuration and create
+// our router and server
+func parseTopology(top *topology.Topology, sid uint16, inf int, cfg Config, l *log.Logger) *server.Server {
     var table map[uint16]*neighbor
-    table = make(map[uint16]*neighbor, NumServers)
+    table = make(map[uint16]*neighbor, len(top.Servers))
+
+    r := Router{
+        ID: sid,
+        table: table,
+        topologyFile: cfg.TopologyFile,
+        PacketChan: make(chan types.Packet, 50000),
+        log: l,
+        inf: inf,
+        weights: cfg.Weights,
+        probeInterval: cfg.ProbeInterval,
+        probeUnit: cfg.ProbeUnit,
+        probes: make(map[uint16]*probeState),
+        helloInterval: cfg.HelloInterval,
+        deadMultiplier: cfg.DeadMultiplier,
+        discovery: cfg.Discovery,
+        discoveryCost: cfg.DiscoveryCost,
+        peers: cfg.Peers,
+        legacy: cfg.Legacy,
+        checksum: cfg.Checksum,
+        checksumFailures: make(map[uint16]int),
+        triggered: cfg.Triggered,
+        rng: rand.New(rand.NewSource(time.Now().UnixNano())),
+    }
+
+    // The engine works out our routes
+    switch cfg.Engine {
+    case EngineLS:
+        lsCfg := cfg.LS
+        lsCfg.Infinity = inf
+        r.engine = ls.New(sid, lsCfg, l)
+
+        // Link state floods changes right away
+        r.triggered = true
+    default:
+        dvCfg := cfg.DV
+        dvCfg.Infinity = inf
+        dvCfg.PathVector = cfg.Engine == EnginePV
+        dvCfg.Sequenced = cfg.Engine == EngineDSDV
+        dvCfg.Feasibility = cfg.Engine == EngineBabel
+
+        // Route requests need to go out right away
+        if dvCfg.Feasibility {
+            r.triggered = true
+        }
+        r.engine = dv.New(sid, dvCfg, l)
+    }
 
     var bindy string
     for _, server := range top.Servers {
@@ -45,12 +145,9 @@ func (n *Network) parseTopology(top *topology.Topology, sid uint16, l *log.Logge
                 bindy: server.Bindy,
 
                 active: true,
-                nextHop: uint16(0),
                 directCost: 0,
-                linkCost: 0,
 
                 updated: time.Now(),
-                forwarded: time.Now(),
             }
             table[server.ID] = &s
             continue
@@ -61,88 +158,33 @@ func (n *Network) parseTopology(top *topology.Topology, sid uint16, l *log.Logge
 
             IP: server.IP,
             port: server.Port,
-            nextHop: uint16(0),
             bindy: server.Bindy,
 
             active: true,
-            directCost: server.Cost,
-            linkCost: server.Cost,
+            directCost: r.metric(server.Cost),
+            metrics: server.Metrics,
 
             updated: time.Now(),
-            forwarded: time.Now(),
-        }
-        if s.directCost != Inf {
-            s.nextHop = s.ID
         }
         table[server.ID] = &s
-        r := n.createRouter(server.ID, server.Cost, l)
-        routers[server.ID] = r
-    }
-
-    r := Router{
-        ID: sid,
-        network: n,
-        table: table,
-        PacketChan: make(chan []byte, 50000),
-        UpdateChan: make(chan routingTable, 100),
-        log: l,
-    }
-    go r.routerThread()
-    go r.packetThread()
 
-    routers[sid] = &r
-    n.Routers = routers
-    n.Channels[r.ID] = r.UpdateChan
-
-    server := server.New(r.PacketChan, sid, bindy, &r, l)
-    return server
-}
-
-// createRouter creates a new router that will be used to
-// keep track of the other servers routing tables
-func (n *Network) createRouter(id uint16, cost int, l *log.Logger) *Router {
-    var table map[uint16]*neighbor
-    table = make(map[uint16]*neighbor, NumServers)
-
-    var i uint16 = 1
-    for ; i <= uint16(NumServers); i++ {
-
-        if i == id {
-            s := neighbor{
-                ID: i,
-                active: true,
-                nextHop: uint16(0),
-                directCost: cost,
-                linkCost: Inf,
-                updated: time.Now(),
-                forwarded: time.Now(),
-            }
-            table[i] = &s
-            continue
+        // Links with attributes use their composite cost
+        if s.metrics.Set != 0 {
+            s.directCost = r.metric(r.weights.Cost(s.metrics))
         }
 
-        s := neighbor{
-            ID: i,
-            nextHop: uint16(0),
-            active: false,
-            directCost: Inf,
-            linkCost: Inf,
-            updated: time.Now(),
-            forwarded: time.Now(),
-        }
-        table[i] = &s
+        // Let the engine know about our link to the neighbor
+        r.engine.SetLinkCost(s.ID, s.directCost)
     }
-
-    r := Router{
-        ID: id,
-        network: n,
-        table: table,
-        PacketChan: make(chan []byte, 50000),
-        UpdateChan: make(chan routingTable, 100),
-        log: l,
+    go r.packetThread()
+    go r.timerThread()
+    if r.probeInterval > 0 {
+        go r.probeThread()
+    }
+    if r.helloInterval > 0 {
+        go r.helloThread()
     }
-    go r.routerThread()
 
-    n.Channels[r.ID] = r.UpdateChan
-    return &r
+    server := server.New(r.PacketChan, sid, bindy, &r, l)
+    return server
 }
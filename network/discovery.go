@@ -0,0 +1,61 @@
+package network
+
+import (
+    "dvr/message"
+    "net"
+    "strconv"
+    "time"
+)
+
+// discover adds a server that sent us a hello, but isn't in our table, as
+// a neighbor with the discovery cost
+func (r *Router) discover(msg *message.Message) {
+    id, ok := selfEntry(msg)
+    if !ok {
+        return
+    }
+
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    if _, ok := r.table[id]; ok || id == r.ID {
+        return
+    }
+
+    ip := msg.IP.String()
+    s := neighbor{
+        ID: id,
+
+        IP: ip,
+        port: int(msg.Port),
+        bindy: net.JoinHostPort(ip, strconv.Itoa(int(msg.Port))),
+
+        active: true,
+        directCost: r.metric(r.discoveryCost),
+
+        updated: time.Now(),
+
+        learned: true,
+    }
+    r.table[id] = &s
+
+    r.log.OutServer("\nDISCOVERED SERVER %d AT %s\n", id, s.bindy)
+    r.log.OutApp("\nPlease enter a command: ")
+
+    r.apply(func() bool {
+        return r.engine.SetLinkCost(id, s.directCost)
+    })
+}
+
+// selfEntry returns the ID of the server that sent a hello, which is the
+// only entry in it, with cost 0
+func selfEntry(msg *message.Message) (uint16, bool) {
+    if len(msg.N) != 1 {
+        return 0, false
+    }
+    n := msg.N[0]
+    if n.Cost != 0 || n.ID == 0 {
+        return 0, false
+    }
+    return n.ID, true
+}
@@ -0,0 +1,96 @@
+package network
+
+import (
+    "dvr/message"
+    "time"
+)
+
+// helloThread is a thread for sending hellos to our neighbors every hello
+// interval, and taking down the links to the neighbors whose hellos have
+// stopped
+func (r *Router) helloThread() {
+    tick := time.NewTicker(r.helloInterval)
+    defer tick.Stop()
+
+    hello := &message.Hello{
+        Interval: uint32(r.helloInterval / time.Millisecond),
+        Multiplier: uint16(r.deadMultiplier),
+    }
+
+    for {
+        select {
+        case now := <-tick.C:
+            r.mu.Lock()
+            if r.stopped {
+                r.mu.Unlock()
+                return
+            }
+            // The timer thread checks every second, which is too slow
+            // for hellos faster than that
+            r.checkHellos(now)
+            neighbors := r.linkedNeighbors()
+            msg := r.controlMessage()
+            r.mu.Unlock()
+
+            msg.Hello = hello
+            sent := make(map[string]bool, len(neighbors))
+            for id, bindy := range neighbors {
+                sent[bindy] = true
+                if err := r.sendControl(bindy, msg); err != nil {
+                    r.log.OutError("\nr.helloThread: failed to send hello to neighbor %d - %+v\n", id, err)
+                }
+            }
+
+            // The servers at our peer addresses might not know us yet
+            for _, peer := range r.peers {
+                if sent[peer] {
+                    continue
+                }
+                if err := r.sendControl(peer, msg); err != nil {
+                    r.log.OutError("\nr.helloThread: failed to send hello to peer %s - %+v\n", peer, err)
+                }
+            }
+        }
+    }
+}
+
+// handleHello takes in a hello from a neighbor, bringing its link back up
+// if it was down
+func (r *Router) handleHello(senderID uint16, h *message.Hello) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    // We don't listen to servers we've disabled our link to, or that
+    // were pruned or reloaded away since we looked up their ID
+    server, ok := r.table[senderID]
+    if !ok || !r.reachable(server.directCost) {
+        return
+    }
+
+    server.helloed = time.Now()
+    server.deadInterval = time.Duration(h.Interval) * time.Millisecond * time.Duration(h.Multiplier)
+    r.heard(server)
+}
+
+// checkHellos takes down the links to the neighbors we haven't had a hello
+// from within the dead interval their hellos gave us. Neighbors that have
+// never sent us a hello are left to CheckUpdates. The router's lock must be
+// held.
+func (r *Router) checkHellos(now time.Time) {
+    for _, server := range r.table {
+        if server.ID == r.ID || !server.active || server.deadInterval == 0 {
+            continue
+        }
+        if now.Sub(server.helloed) < server.deadInterval {
+            continue
+        }
+        server.active = false
+        r.log.OutError("\nr.checkHellos: Haven't received a hello from server (%d) in %s, disabling the link.\n", server.ID, server.deadInterval)
+        r.log.OutApp("\nPlease enter a command: ")
+
+        id := server.ID
+        r.apply(func() bool {
+            return r.engine.SetLinkCost(id, r.inf)
+        })
+    }
+}
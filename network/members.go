@@ -0,0 +1,65 @@
+package network
+
+import (
+    "dvr/message"
+    "dvr/types"
+    "net"
+    "strconv"
+    "time"
+)
+
+// learn adds the servers in a routing update that aren't in our table, so
+// that we can advertise and send packets to them. We don't have a link to
+// them, and only know of them from our neighbors' updates. The router's
+// lock must be held.
+func (r *Router) learn(msg *message.Message) {
+    for _, n := range msg.N {
+        id := n.ID
+        if _, ok := r.table[id]; ok || id == r.ID || n.Port == 0 || !r.reachable(int(n.Cost)) {
+            continue
+        }
+
+        ip := n.IP.String()
+        bindy := net.JoinHostPort(ip, strconv.Itoa(int(n.Port)))
+        r.table[id] = &neighbor{
+            ID: id,
+
+            IP: ip,
+            port: int(n.Port),
+            bindy: bindy,
+
+            directCost: r.inf,
+
+            updated: time.Now(),
+
+            learned: true,
+        }
+
+        r.log.OutServer("\nLEARNED OF SERVER %d AT %s\n", id, bindy)
+        r.log.OutApp("\nPlease enter a command: ")
+    }
+}
+
+// prune drops the servers we learned of while running once the engine has
+// garbage collected our route to them, so that our table shrinks again as
+// servers leave the network. The router's lock must be held.
+func (r *Router) prune() {
+    for _, rt := range r.engine.Table() {
+        server, ok := r.table[rt.ID]
+        if !ok || !server.learned || rt.State != types.StateDeleted {
+            continue
+        }
+
+        // A link that's still up keeps the server around
+        if server.active && r.reachable(server.directCost) {
+            continue
+        }
+
+        r.engine.Forget(rt.ID)
+        delete(r.table, rt.ID)
+        delete(r.probes, rt.ID)
+
+        r.log.OutServer("\nFORGOT SERVER %d\n", rt.ID)
+        r.log.OutApp("\nPlease enter a command: ")
+    }
+}
@@ -0,0 +1,177 @@
+package network
+
+import (
+    "dvr/message"
+    "math"
+    "time"
+)
+
+// The measured cost of a link only replaces the one it has when it's off by
+// more than probeHysteresis of it, and by more than 1, so that jitter
+// doesn't flap our routes. At most maxLoss of the probes count as lost, so
+// the cost of a lossy link stays finite.
+const (
+    probeHysteresis = 0.25
+    maxLoss = 0.9
+)
+
+// probeState is what we've measured of the link to a neighbor
+type probeState struct {
+    // The sequence number of the last probe we sent, and when each
+    // probe we're waiting on a reply to was sent
+    seq uint32
+    outstanding map[uint32]time.Time
+
+    // The smoothed round trip time and loss, which is the fraction of
+    // probes lost
+    srtt time.Duration
+    loss float64
+    measured bool
+}
+
+// probeThread is a thread for probing the links to our neighbors every probe
+// interval, and setting their cost from what we measure
+func (r *Router) probeThread() {
+    tick := time.NewTicker(r.probeInterval)
+    defer tick.Stop()
+
+    for {
+        select {
+        case now := <-tick.C:
+            r.mu.Lock()
+            if r.stopped {
+                r.mu.Unlock()
+                return
+            }
+            neighbors := r.linkedNeighbors()
+            probes := make(map[uint16]*message.Probe, len(neighbors))
+            for id := range neighbors {
+                // Only the end of a link with the lower ID probes it and
+                // sets its cost, so the two ends don't fight over it
+                if id < r.ID {
+                    continue
+                }
+                probes[id] = r.nextProbe(id, now)
+            }
+            r.mu.Unlock()
+
+            for id, p := range probes {
+                r.sendProbe(id, neighbors[id], p)
+                r.applyProbe(id)
+            }
+        }
+    }
+}
+
+// nextProbe counts the probes to a neighbor that haven't been replied to
+// within a probe interval as lost, and returns the next one to send. The
+// router's lock must be held.
+func (r *Router) nextProbe(id uint16, now time.Time) *message.Probe {
+    ps, ok := r.probes[id]
+    if !ok {
+        ps = &probeState{outstanding: make(map[uint32]time.Time)}
+        r.probes[id] = ps
+    }
+
+    for seq, sent := range ps.outstanding {
+        if now.Sub(sent) >= r.probeInterval {
+            delete(ps.outstanding, seq)
+            ps.loss = ps.loss*7/8 + 1.0/8
+        }
+    }
+
+    ps.seq++
+    ps.outstanding[ps.seq] = now
+    return &message.Probe{Seq: ps.seq, Sent: now.UnixNano()}
+}
+
+// sendProbe sends a probe, or the reply to one, to a neighbor
+func (r *Router) sendProbe(id uint16, bindy string, p *message.Probe) {
+    r.mu.Lock()
+    msg := r.controlMessage()
+    r.mu.Unlock()
+
+    msg.Probe = p
+    if err := r.sendControl(bindy, msg); err != nil {
+        r.log.OutError("\nr.sendProbe: failed to send probe to neighbor %d - %+v\n", id, err)
+    }
+}
+
+// handleProbe replies to a probe from a neighbor, or takes in the reply to
+// one of ours
+func (r *Router) handleProbe(senderID uint16, p *message.Probe) {
+    if p.Flags&message.ProbeReply == 0 {
+        r.mu.Lock()
+        server, ok := r.table[senderID]
+        if !ok {
+            r.mu.Unlock()
+            return
+        }
+        bindy := server.bindy
+        r.mu.Unlock()
+
+        reply := *p
+        reply.Flags |= message.ProbeReply
+        r.sendProbe(senderID, bindy, &reply)
+        return
+    }
+
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    // Replies that come in after we counted the probe as lost don't count
+    ps, ok := r.probes[senderID]
+    if !ok {
+        return
+    }
+    if _, ok := ps.outstanding[p.Seq]; !ok {
+        return
+    }
+    delete(ps.outstanding, p.Seq)
+
+    rtt := time.Since(time.Unix(0, p.Sent))
+    if !ps.measured {
+        ps.srtt = rtt
+        ps.measured = true
+    } else {
+        ps.srtt = ps.srtt*7/8 + rtt/8
+    }
+    ps.loss = ps.loss * 7 / 8
+}
+
+// applyProbe sets the cost of the link to a neighbor from what we've
+// measured, if it's different enough from the cost the link has
+func (r *Router) applyProbe(id uint16) {
+    r.mu.Lock()
+    ps, ok := r.probes[id]
+    server, found := r.table[id]
+    if !ok || !found || !ps.measured || !r.reachable(server.directCost) {
+        r.mu.Unlock()
+        return
+    }
+
+    // The cost is the round trip time in probe units, scaled up by how
+    // many tries it takes to get through the loss. A measured link is never
+    // unreachable, only very costly, so we can keep probing it. The cap goes
+    // on before the cost is made an int, which a slow link could overflow.
+    loss := math.Min(ps.loss, maxLoss)
+    units := float64(ps.srtt) / float64(r.probeUnit)
+    cost := int(math.Min(math.Ceil(units / (1 - loss)), float64(r.inf - 1)))
+    if cost < 1 {
+        cost = 1
+    }
+
+    diff := math.Abs(float64(cost - server.directCost))
+    current := server.directCost
+    srtt := ps.srtt
+    r.mu.Unlock()
+
+    if diff <= 1 || diff <= probeHysteresis*float64(current) {
+        return
+    }
+
+    r.log.OutDebug("\nr.applyProbe: link to server %d measured at %d (rtt %s, loss %.2f)\n", id, cost, srtt.Round(time.Microsecond), loss)
+    if err := r.Update(r.ID, id, cost); err != nil {
+        r.log.OutError("\nr.applyProbe: failed to update the link to server %d - %+v\n", id, err)
+    }
+}
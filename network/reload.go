@@ -0,0 +1,110 @@
+package network
+
+import (
+    "dvr/topology"
+    "time"
+
+    "github.com/pkg/errors"
+)
+
+// Reload parses the topology file again, and applies the differences between
+// it and our table as link updates, disables and enables. Servers we've
+// learned of while running, and the routes to them, are left alone.
+func (r *Router) Reload() error {
+    if r.topologyFile == "" {
+        return errors.Wrapf(ReloadErr, "r.Reload: failed to reload topology")
+    }
+    top, _, err := topology.ParseTopology(r.topologyFile, r.ID)
+    if err != nil {
+        return errors.Wrapf(err, "r.Reload: failed to reload topology file %s", r.topologyFile)
+    }
+
+    // Work out what changed while holding the lock, the link updates and
+    // disables take it themselves
+    var updates []func() error
+    r.mu.Lock()
+    for _, s := range top.Servers {
+        if s.ID == r.ID {
+            continue
+        }
+
+        cost := r.metric(s.Cost)
+        if s.Metrics.Set != 0 {
+            cost = r.metric(r.weights.Cost(s.Metrics))
+        }
+
+        server, ok := r.table[s.ID]
+        if !ok {
+            server = &neighbor{
+                ID: s.ID,
+                directCost: r.inf,
+                updated: time.Now(),
+            }
+            r.table[s.ID] = server
+            r.log.OutServer("\nRELOAD: added server %d at %s\n", s.ID, s.Bindy)
+        }
+
+        // The file knows about the server now, even if we learned of it
+        // first
+        server.learned = false
+        if server.bindy != s.Bindy {
+            if server.bindy != "" {
+                r.log.OutServer("\nRELOAD: server %d moved from %s to %s\n", s.ID, server.bindy, s.Bindy)
+            }
+            server.IP = s.IP
+            server.port = s.Port
+            server.bindy = s.Bindy
+        }
+
+        id, m := s.ID, s.Metrics
+        switch {
+        case !r.reachable(cost) && r.reachable(server.directCost):
+            r.log.OutServer("\nRELOAD: disabling link to server %d\n", id)
+            updates = append(updates, func() error {
+                return r.Disable(id)
+            })
+        case r.reachable(cost) && !r.reachable(server.directCost):
+            r.log.OutServer("\nRELOAD: enabling link to server %d with cost %d\n", id, cost)
+            server.metrics = m
+            server.active = true
+            server.updated = time.Now()
+            r.setDirectCost(server, cost)
+        case r.reachable(cost) && m.Set != 0 && m != server.metrics:
+            r.log.OutServer("\nRELOAD: updating link to server %d to %s\n", id, m)
+            updates = append(updates, func() error {
+                return r.UpdateMetrics(r.ID, id, m)
+            })
+        case r.reachable(cost) && (cost != server.directCost || server.metrics.Set != m.Set):
+            r.log.OutServer("\nRELOAD: updating link to server %d from cost %d to %d\n", id, server.directCost, cost)
+            updates = append(updates, func() error {
+                return r.Update(r.ID, id, cost)
+            })
+        }
+    }
+
+    // Servers taken out of the file lose their link, and are forgotten
+    // once the routes to them are garbage collected
+    for id, server := range r.table {
+        if _, ok := top.Servers[int(id)]; ok || id == r.ID || server.learned {
+            continue
+        }
+        server.learned = true
+        r.log.OutServer("\nRELOAD: server %d was removed from the topology file\n", id)
+        if r.reachable(server.directCost) {
+            id := id
+            updates = append(updates, func() error {
+                return r.Disable(id)
+            })
+        }
+    }
+    r.mu.Unlock()
+
+    // One change failing doesn't stop the others
+    var updateErr error
+    for _, update := range updates {
+        if err := update(); err != nil && updateErr == nil {
+            updateErr = errors.Wrapf(err, "r.Reload: failed to apply topology change")
+        }
+    }
+    return updateErr
+}
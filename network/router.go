@@ -1,120 +1,93 @@
 package network
 
-import "fmt"
-
-// routerThread is a thread for handling routing table updates
-func (r *Router) routerThread() {
-    for {
-        select {
-        case update := <-r.UpdateChan:
-            r.UpdateTable(update)
-        }
-    }
-}
-
-// routerThread is a thread for handling new packet updates
+import (
+    "dvr/client"
+    "dvr/message"
+    "dvr/types"
+    "fmt"
+    "hash/fnv"
+    "net"
+    "strconv"
+    "strings"
+)
+
+// packetThread is a thread for handling new packet updates
 func (r *Router) packetThread() {
+    // Packets are handled one at a time, so they can share a decoder and
+    // the message it decodes into
+    var d message.Decoder
+    var msg message.Message
     for {
         select {
         case packet := <- r.PacketChan:
-            r.newPacket(packet)
+            r.newPacket(&d, &msg, packet)
         }
     }
 }
 
-// UpdateTable updates the routing table
-func (r *Router) UpdateTable(rt routingTable) {
-    r.mu.Lock()
-    defer r.mu.Unlock()
+// Bind has us send from the server's listener, so that our packets come
+// from the address our neighbors know us by
+func (r *Router) Bind(conn net.PacketConn) {
+    r.connMu.Lock()
+    defer r.connMu.Unlock()
+    r.conn = conn
+}
 
-    var updated bool
+// newClient creates a client to send a packet to a bind address, from the
+// server's listener once it's bound
+func (r *Router) newClient(bindy string) (*client.Client, error) {
+    r.connMu.Lock()
+    conn := r.conn
+    r.connMu.Unlock()
 
-    // Determine who the sender of the packet is
-    var senderID uint16
-    for dest, n := range rt.Table {
-        if dest == n.ID && n.Cost == 0 {
-            senderID = n.ID
-        }
+    if conn == nil {
+        return client.NewClient(bindy)
+    }
+    return client.NewClientFrom(conn, bindy)
+}
+
+// apply runs a call into the routing engine and works out which of our
+// routes it changed or wants sent, so they go out in the next triggered
+// update. The router's lock must be held.
+func (r *Router) apply(call func() bool) {
+    before := make(map[uint16]types.Route, len(r.table))
+    for _, rt := range r.engine.Table() {
+        before[rt.ID] = rt
     }
 
-    for destination, n := range rt.Table {
-        _, ok := r.table[destination]
+    if !call() {
+        return
+    }
+    r.dirty = true
 
-        if senderID == destination {
+    for _, rt := range r.engine.Table() {
+        prev, ok := before[rt.ID]
+        if ok && prev.NextHop == rt.NextHop && prev.Cost == rt.Cost && prev.Seq == rt.Seq && sameIDs(prev.Path, rt.Path) && sameIDs(prev.NextHops, rt.NextHops) {
             continue
         }
-
-        cost := n.Cost + rt.Table[r.ID].Cost
-        if ok {
-            // If our cost is larger than the incoming cost, update our table.
-            if r.table[destination].linkCost > cost && cost > 0 {
-                r.table[destination].linkCost = cost
-
-                // Is our linkCost is larger than 12, then we likely
-                // have some sort of route poisoning .. this attempts to
-                // solve that .. unsure if it does so successfully
-                if r.table[destination].linkCost > 12 {
-                    r.table[destination].linkCost = Inf
-                    r.table[destination].directCost = Inf
-                    updated = true
-                    continue
-                }
-                // Now we'll determine the routers next hop value
-                if _, ok := r.table[senderID]; ok {
-                    // Is the router's ID the same as the router this
-                    // table came from?
-                    if r.ID == rt.ID {
-                        r.table[destination].nextHop = destination
-                        updated = true
-                        continue
-                    }
-                    if r.table[senderID].nextHop == r.table[destination].nextHop  {
-                        r.table[destination].nextHop = destination
-                        updated = true
-                        continue
-                    }
-                }
-                r.table[destination].nextHop = senderID
-                updated = true
-            }
+        if server, ok := r.table[rt.ID]; ok {
+            server.changed = true
         }
     }
 
-    if updated {
-        go r.sendToNeighbors()
-    }
-}
-
-// sendToNeighbors sends routing table updates to the neighboring
-// routers in the network
-func (r *Router) sendToNeighbors() {
-    r.mu.Lock()
-    defer r.mu.Unlock()
-
-    tableUp := make(map[uint16]tableUpdate, len(r.table))
-    for id, server := range r.table {
-        t := tableUpdate{
-            ID: server.ID,
-            Cost: server.linkCost,
+    // and the routes the engine wants sent even though they're the same
+    for _, id := range r.engine.Wanted() {
+        if server, ok := r.table[id]; ok {
+            server.changed = true
         }
-        tableUp[id] = t
     }
 
-    rt := routingTable{
-        ID: r.ID,
-        Table: tableUp,
-    }
+    go r.triggerUpdate()
+}
 
-    // Get the router update channels from the network
-    channels := r.network.Channels
-    for id, c := range channels {
-        if id == r.ID {
-            continue
+// route returns the engine's route to a destination
+func (r *Router) route(id uint16) (types.Route, bool) {
+    for _, rt := range r.engine.Table() {
+        if rt.ID == id {
+            return rt, r.reachable(rt.Cost) && rt.NextHop != 0
         }
-
-        // Send the routing table to the update channel 
-        c <- rt
     }
+    return types.Route{}, false
 }
 
 // GetNeighborID returns the ID of the neighbor associated with the provided port
@@ -131,3 +104,65 @@ func (r *Router) GetNeighborID(port string) uint16 {
     }
     return id
 } // }}}
+
+// sameIDs checks if two lists of IDs, like paths, are the same
+func sameIDs(a, b []uint16) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+    return true
+}
+
+// flowHop picks the next hop for a flow from the equal cost next hops of a
+// route. Packets between the same source and destination always hash to
+// the same next hop, so a flow isn't reordered.
+func flowHop(rt types.Route, src, dst uint16) uint16 {
+    if len(rt.NextHops) == 0 {
+        return rt.NextHop
+    }
+    h := fnv.New32a()
+    h.Write([]byte{byte(src >> 8), byte(src), byte(dst >> 8), byte(dst)})
+    return rt.NextHops[h.Sum32() % uint32(len(rt.NextHops))]
+}
+
+// formatHops formats the next hops of a route, like 2,3
+func formatHops(rt types.Route) string {
+    if len(rt.NextHops) == 0 {
+        return strconv.Itoa(int(rt.NextHop))
+    }
+    hops := make([]string, len(rt.NextHops))
+    for i, id := range rt.NextHops {
+        hops[i] = strconv.Itoa(int(id))
+    }
+    return strings.Join(hops, ",")
+}
+
+// formatPath formats the path of a route starting from us, like 1 -> 2 -> 3
+func (r *Router) formatPath(path []uint16) string {
+    hops := make([]string, 0, len(path) + 1)
+    hops = append(hops, strconv.Itoa(int(r.ID)))
+    for _, id := range path {
+        hops = append(hops, strconv.Itoa(int(id)))
+    }
+    return strings.Join(hops, " -> ")
+}
+
+// metric caps a cost at the router's infinity, anything at or above it
+// is unreachable
+func (r *Router) metric(cost int) int {
+    if cost >= r.inf {
+        return r.inf
+    }
+    return cost
+}
+
+// reachable checks if a cost is below the router's infinity
+func (r *Router) reachable(cost int) bool {
+    return cost < r.inf
+}
+
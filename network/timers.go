@@ -0,0 +1,24 @@
+package network
+
+import "time"
+
+// timerThread is a thread for running the routing engine's timers, taking
+// down the links to the neighbors whose hellos have stopped, and dropping
+// the servers that have left the network
+func (r *Router) timerThread() {
+    tick := time.NewTicker(time.Second)
+    defer tick.Stop()
+
+    for {
+        select {
+        case now := <-tick.C:
+            r.mu.Lock()
+            r.checkHellos(now)
+            r.apply(func() bool {
+                return r.engine.Tick(now)
+            })
+            r.prune()
+            r.mu.Unlock()
+        }
+    }
+}
@@ -0,0 +1,71 @@
+package network
+
+import (
+    "time"
+)
+
+// Triggered updates are rate limited by a random damping timer, so that
+// a burst of changes goes out in a few small updates instead of a flood
+const (
+    minDamping = 1 * time.Second
+    maxDamping = 5 * time.Second
+)
+
+// triggerUpdate sends the routes that have changed to our neighbors right
+// away. If the damping timer is already running, they'll go out when
+// it fires instead.
+func (r *Router) triggerUpdate() {
+    if !r.triggered {
+        return
+    }
+
+    r.mu.Lock()
+    if r.damping || r.stopped {
+        r.mu.Unlock()
+        return
+    }
+    r.damping = true
+    r.mu.Unlock()
+
+    for {
+        r.mu.Lock()
+        changed := make(map[uint16]bool, len(r.table))
+        for id, server := range r.table {
+            if server.changed {
+                changed[id] = true
+                server.changed = false
+            }
+        }
+
+        dirty := r.dirty
+        r.dirty = false
+
+        // Nothing changed while we were waiting, so we can stop the timer
+        if (!dirty && len(changed) == 0) || r.stopped {
+            r.damping = false
+            r.mu.Unlock()
+            return
+        }
+        neighbors := r.linkedNeighbors()
+        wait := minDamping + time.Duration(r.rng.Int63n(int64(maxDamping-minDamping)))
+        r.mu.Unlock()
+
+        for id, bindy := range neighbors {
+            packet, err := r.preparePacket(id, changed)
+            if err != nil {
+                r.log.OutError("\nr.triggerUpdate: failed to prepare update for neighbor %d - %+v\n", id, err)
+                continue
+            }
+
+            // Create a new client connection and send the packet
+            c, err := r.newClient(bindy)
+            if err != nil {
+                r.log.OutError("\nr.triggerUpdate: failed to send update to neighbor %d - %+v\n", id, err)
+                continue
+            }
+            c.SendPacket(packet, r.log)
+        }
+
+        time.Sleep(wait)
+    }
+}
@@ -1,8 +1,14 @@
 package network
 
 import (
+    "dvr/dv"
     "dvr/log"
+    "dvr/ls"
+    "dvr/metric"
+    "dvr/types"
     "errors"
+    "math/rand"
+    "net"
     "sync"
     "time"
 )
@@ -13,35 +19,145 @@ var DisErr error = errors.New("cannot disable a non neighbor link")
 var DisSErr error = errors.New("cannot disable link to youself")
 // SendErr is the error message to display on send error - self 
 var SendErr error = errors.New("cannot send packet to yourself")
+// RouteErr is the error message to display when there's no route to a server
+var RouteErr error = errors.New("no route to server")
+// EngineErr is the error message to display for an unknown routing engine
+var EngineErr error = errors.New("routing engine must be one of 'dv', 'pv', 'dsdv', 'babel' or 'ls'")
+// ReloadErr is the error message to display when there's no topology file to reload
+var ReloadErr error = errors.New("no topology file to reload")
+// InfErr is the error message to display for an infinity metric that can't be sent
+var InfErr error = errors.New("infinity must be between 1 and 65535")
+// MetricCostErr is the error message to display when link attributes give a cost the network takes as unreachable
+var MetricCostErr error = errors.New("the link attributes give a cost at or above infinity, raise it with -m or lower the weights with -w")
+// LegacyErr is the error message to display for options the assignment's message format can't carry
+var LegacyErr error = errors.New("legacy mode only works with the 'dv' engine and IPv4 addresses, without probes, hellos, discovery or checksums")
+// Engine is the routing algorithm a router uses
+type Engine int
+
+const (
+    // EngineDV is distance vector routing, using bellman-ford
+    EngineDV Engine = iota
+    // EngineLS is link state routing, using dijkstra
+    EngineLS
+    // EnginePV is distance vector routing where routes carry the path
+    // they go through, so routes that would loop can be rejected
+    EnginePV
+    // EngineDSDV is distance vector routing where routes carry a sequence
+    // number stamped by their destination, and newer routes win
+    EngineDSDV
+    // EngineBabel is distance vector routing where routes are checked
+    // against a feasibility distance before they're used, like babel
+    EngineBabel
+)
 
-type tableUpdate struct {
-    ID uint16
-    Cost int
-}
-
-// routingTable holds the routing table for the router
-type routingTable struct {
-    ID uint16
-    Table map[uint16]tableUpdate
+// Config holds the startup options for a router
+type Config struct {
+    // The topology file we were started with, which the reload command
+    // parses again
+    TopologyFile string
+
+    // The routing algorithm to use
+    Engine Engine
+
+    // The cost at which a destination is unreachable, 0 to use the
+    // topology file's or the default
+    Infinity int
+
+    // Whether to send changed routes to our neighbors right away
+    Triggered bool
+
+    // How much each link attribute counts towards the composite cost of
+    // links that have attributes
+    Weights metric.Weights
+
+    // How often to probe the links to our neighbors and set their cost
+    // from the round trip time and loss we measure, 0 to not probe, and
+    // the round trip time that counts as a cost of 1
+    ProbeInterval time.Duration
+    ProbeUnit time.Duration
+
+    // How often to send hellos to our neighbors, 0 to not send any, and
+    // how many of them can be missed before a neighbor takes us for dead
+    HelloInterval time.Duration
+    DeadMultiplier int
+
+    // Whether to add servers that send us hellos as neighbors, with the
+    // discovery cost, and the addresses to send hellos to so that the
+    // servers there can discover us
+    Discovery bool
+    DiscoveryCost int
+    Peers []string
+
+    // Whether to send messages in the assignment's format, without a
+    // header, so we can talk to servers that only speak that
+    Legacy bool
+
+    // Whether to end the messages we send with a checksum
+    Checksum bool
+
+    // The options for the distance vector and link state engines
+    DV dv.Config
+    LS ls.Config
 }
 
 // Router holds the routing information for a server
 type Router struct {
     ID uint16
-    network *Network
     table map[uint16]*neighbor
-    PacketChan chan []byte
-    UpdateChan chan routingTable
+    topologyFile string
+    PacketChan chan types.Packet
     log *log.Logger
     mu sync.RWMutex
-}
 
-// Network holds routing information for all servers on the network
-type Network struct {
-    Channels map[uint16]chan routingTable
-    Routers map[uint16]*Router
+    // The routing engine that works out our routes
+    engine types.RoutingEngine
 
-    mu sync.RWMutex
+    // The cost at which a destination is unreachable
+    inf int
+
+    // How the composite cost of a link is worked out from its attributes
+    weights metric.Weights
+
+    // How often we probe the links to our neighbors, the round trip time
+    // that counts as a cost of 1, and what we've measured of each of them
+    probeInterval time.Duration
+    probeUnit time.Duration
+    probes map[uint16]*probeState
+
+    // How often we send hellos to our neighbors, and how many of them
+    // can be missed before we're taken for dead
+    helloInterval time.Duration
+    deadMultiplier int
+
+    // Whether we add servers that send us hellos as neighbors, the cost
+    // they get, and the addresses we send hellos to so they can find us
+    discovery bool
+    discoveryCost int
+    peers []string
+
+    // Whether we send messages in the assignment's format
+    legacy bool
+
+    // Whether we end our messages with a checksum, and how many messages
+    // from each neighbor failed theirs
+    checksum bool
+    checksumFailures map[uint16]int
+
+    // The server's listener, which we send from once it's bound
+    conn net.PacketConn
+    connMu sync.Mutex
+
+    // Whether to send changed routes to our neighbors right away, and
+    // whether the damping timer between triggered updates is running
+    triggered bool
+    damping bool
+    rng *rand.Rand
+
+    // Whether the engine has changed something that hasn't been sent yet
+    dirty bool
+
+    // Whether our server has crashed, we don't send anything after that
+    stopped bool
 }
 
 // neighbor is essentially a server, but I want my server to
@@ -60,26 +176,24 @@ type neighbor struct {
     // The direct cost between source and destination servers
     directCost int
 
-    // The last cost of the link that was in the routing table
-    lastCost int
-
-    // The current link cost between the server & destination that's been
-    // updated using the bellman-ford algorithm
-    linkCost int
-
-    // The server that'll be hopped to in order to get to destination server
-    nextHop uint16
+    // The attributes of our direct link, if it has any
+    metrics metric.Link
 
     // The last time this neighbors was updated (last time we received a
     // packet from this neighbor)
     updated time.Time
 
-    // The last time we forwarded a message to a neighbor
-    forwarded time.Time
+    // The last time we got a hello from this neighbor, and how long
+    // its hellos say to wait for the next one before taking it for dead
+    helloed time.Time
+    deadInterval time.Duration
 
-    // Whether or not this link has been disabled or updated, will be used
-    // to keep data in sync
+    // Whether or not our route to this server has changed since the last
+    // update we sent, will be used to keep data in sync
     changed bool
 
-    mu sync.Mutex
+    // Whether we learned of this server while running, instead of from
+    // the topology file. Learned servers are dropped once our route to
+    // them is garbage collected.
+    learned bool
 }
@@ -1,102 +1,184 @@
 package network
 
 import (
-    "dvr/client"
     "dvr/message"
+    "dvr/metric"
+    "dvr/types"
     "fmt"
+    "net"
     "time"
 
     "github.com/pkg/errors"
 )
 
-// newPacket handles new packet updates
-func (r *Router) newPacket(packet []byte) {
-    // Create a new message and try to unmarshal the packet into it
-    var msg = &message.Message{}
-    if err := message.UnmarshalMessage(packet, msg); err != nil {
-        r.log.OutError("\nr.newPacket(%+v): Error unmarshaling packet! err = %+v\n", packet, err)
+// newPacket handles new packet updates. The message is decoded with the
+// packet thread's decoder, so nothing in it can be kept past this call.
+func (r *Router) newPacket(d *message.Decoder, msg *message.Message, packet types.Packet) {
+    // Try to unmarshal the packet into the message
+    if err := d.Decode(packet.Data, msg); err != nil {
+        // Nothing in a corrupted message can be trusted, so it's the
+        // address it came from that tells us who sent it
+        if errors.Is(err, message.ErrChecksum) {
+            r.checksumFailed(packet.From)
+        }
+        r.log.OutError("\nr.newPacket(%+v): Error unmarshaling packet! err = %+v\n", packet.Data, err)
         r.log.OutApp("\nPlease enter a command: ")
+        return
     }
 
     // Retrieve the sender ID & Port #
     senderPort := fmt.Sprintf("%d", msg.Port)
     senderID := r.GetNeighborID(senderPort)
 
+    // Hellos from servers we don't know yet are how we discover them
+    if senderID == 0 && msg.Type == message.TypeHello && r.discovery {
+        r.discover(msg)
+        senderID = r.GetNeighborID(senderPort)
+    }
+
     // I was occasionally sending to myself, somehow?
-    if senderID == r.ID {
+    if senderID == r.ID || senderID == 0 {
         return
     }
 
-    r.mu.Lock()
-    // Get the last time this sender was updated &
-    // set this sender to be active, since we received a
-    // message from them
-    updated := r.table[senderID].updated
-    if !r.table[senderID].active {
-        r.table[senderID].active = true
+    switch msg.Type {
+    case message.TypeHello:
+        // Hellos only tell us the sender is alive
+        r.handleHello(senderID, msg.Hello)
+    case message.TypeProbe:
+        // Probes are only for measuring the link they came over
+        r.handleProbe(senderID, msg.Probe)
+    case message.TypeLinkCost:
+        r.handleLinkUpdate(packet.Data, senderID, msg)
+    case message.TypeUpdate, message.TypeLinkState:
+        r.handleUpdate(senderID, msg)
     }
-    r.mu.Unlock()
+}
 
-    // Ensure we didn't *JUST* get sent this packet
-    now := time.Now()
-    oneSecAgo := now.Add(-1*time.Second)
-    if updated.After(oneSecAgo) {
+// handleLinkUpdate handles a link cost update, which may be for us, or need
+// to be forwarded on
+func (r *Router) handleLinkUpdate(packet []byte, senderID uint16, msg *message.Message) {
+    n, ok := linkUpdate(msg)
+    if !ok {
+        r.log.OutError("\nr.handleLinkUpdate: link cost update from server %d has %d entries\n", senderID, len(msg.N))
+        r.log.OutApp("\nPlease enter a command: ")
+        return
+    }
+    if n.ID != r.ID {
+        if r.checkForwarding(packet, senderID, n.ID) {
+            r.log.OutServer("\nSUCCESSFULLY FORWARDED MESSAGE\n")
+            r.log.OutApp("\nPlease enter a command: ")
+        }
         return
     }
+    r.log.OutServer("\nRECEIVED A MESSAGE FROM SERVER %d\n", senderID)
+    r.log.OutApp("\nPlease enter a command: ")
+    if n.Metrics.Set != 0 {
+        r.setLinkMetrics(senderID, n.Metrics)
+    } else {
+        r.setLinkCost(senderID, int(n.Cost))
+    }
+}
+
+// handleUpdate hands a routing update from a neighbor to the engine
+func (r *Router) handleUpdate(senderID uint16, msg *message.Message) {
+    r.mu.Lock()
+    // We don't listen to servers we've disabled our link to, or that
+    // were pruned or reloaded away since we looked up their ID
+    server, ok := r.table[senderID]
+    if !ok || !r.reachable(server.directCost) {
+        r.mu.Unlock()
+        return
+    }
+
+    // Set the updated time for the server & set this sender to be
+    // active, since we received a message from them
+    r.heard(server)
 
     // Let the user know we just got a new packet
     r.log.OutServer("\nRECEIVED A MESSAGE FROM SERVER %d\n", senderID)
     r.log.OutApp("\nPlease enter a command: ")
 
-    // Check if we need to forward the packet to someone else
-    if r.checkForwarding(senderID, packet) {
-        r.log.OutServer("\nSUCCESSFULLY FORWARDED MESSAGE\n")
-        r.log.OutApp("\nPlease enter a command: ")
+    // Servers our neighbors know of might be new to us
+    r.learn(msg)
+
+    r.apply(func() bool {
+        return r.engine.HandleUpdate(senderID, msg)
+    })
+    r.mu.Unlock()
+}
+
+// heard sets the updated time for a neighbor we just got a packet from,
+// and sets it to be active again if it wasn't. The router's lock must be
+// held.
+func (r *Router) heard(server *neighbor) {
+    server.updated = time.Now()
+    if !server.active {
+        server.active = true
+        id, cost := server.ID, server.directCost
+        r.apply(func() bool {
+            return r.engine.SetLinkCost(id, cost)
+        })
     }
+}
 
+// linkUpdate returns the entry of a link cost update, which is sent by the
+// update command and holds a single entry with the new cost of the link
+// between the sender and the server in that entry
+func linkUpdate(msg *message.Message) (*message.Neighbor, bool) {
+    if len(msg.N) != 1 {
+        return nil, false
+    }
+    return &msg.N[0], true
+}
+
+// setLinkCost sets the cost of our direct link to a neighbor, forgetting
+// any attributes the link had
+func (r *Router) setLinkCost(id uint16, cost int) {
     r.mu.Lock()
     defer r.mu.Unlock()
 
-    // Set the updated time for the server
-    r.table[senderID].updated = time.Now()
+    server, ok := r.table[id]
+    if !ok || id == r.ID {
+        return
+    }
+    server.metrics = metric.Link{}
+    r.setDirectCost(server, cost)
+}
 
-    tableUp := make(map[uint16]tableUpdate, len(r.table))
-    // Loop through each of our message neighbors and update the routing table
-    // for the neighbor and the neighbor link costs accordingly.
-    for _, n := range msg.N {
-        t := tableUpdate{
-            ID: n.ID,
-            Cost: int(n.Cost),
-        }
-        tableUp[n.ID] = t
-
-        if t.ID == r.ID {
-            if r.table[senderID].linkCost != t.Cost {
-                if t.Cost == r.table[senderID].directCost {
-                    r.table[senderID].nextHop = senderID
-                }
-                r.table[senderID].linkCost = t.Cost
-            }
-        }
-	}
+// setLinkMetrics sets some of the attributes of our direct link to a
+// neighbor, and its cost to the composite cost of all of them
+func (r *Router) setLinkMetrics(id uint16, m metric.Link) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
 
-    upd := routingTable{
-        ID: senderID,
-        Table: tableUp,
+    server, ok := r.table[id]
+    if !ok || id == r.ID {
+        return
     }
+    server.metrics = server.metrics.Merge(m)
+    r.setDirectCost(server, r.weights.Cost(server.metrics))
+}
 
-    channels := r.network.Channels
-    // We'll send the update to *all* channels in the network,
-    // including our own router
-    for _, c := range channels {
-        c <- upd
+// setDirectCost sets the cost of our direct link to a neighbor. The
+// router's lock must be held.
+func (r *Router) setDirectCost(server *neighbor, cost int) {
+    id := server.ID
+    server.directCost = r.metric(cost)
+
+    // The engine only hears about the link while the neighbor is active
+    if server.active {
+        r.apply(func() bool {
+            return r.engine.SetLinkCost(id, server.directCost)
+        })
     }
 }
 
 // CheckUpdates checks the routers neighbors and see if they've been updated
-// within 3 update intervals & disables them if not
+// within 3 update intervals & deactivates them if not
 func (r *Router) CheckUpdates(interval time.Duration) error {
     r.mu.Lock()
+    defer r.mu.Unlock()
 
     // Check to see if we've gotten an update within the last 3
     // update intervals for each neighbor
@@ -104,50 +186,102 @@ func (r *Router) CheckUpdates(interval time.Duration) error {
     threeUpdates := now.Add(-3 * interval)
 
     for _, server := range r.table {
-        if server.ID == r.ID {
+        if server.ID == r.ID || !r.reachable(server.directCost) {
             continue
         }
-        if server.updated.Before(threeUpdates) && r.table[server.ID].active {
-            r.table[server.ID].active = false
+        if server.updated.Before(threeUpdates) && server.active {
+            server.active = false
             r.log.OutError("\nr.CheckUpdates: Haven't received an update from server (%d) in 3 intervals, disabling the link.\n", server.ID)
             r.log.OutApp("\nPlease enter a command: ")
-            go r.Disable(server.ID)
+
+            // As far as routing goes, the link is down until we hear
+            // from the neighbor again
+            id := server.ID
+            r.apply(func() bool {
+                return r.engine.SetLinkCost(id, r.inf)
+            })
         }
     }
-    r.mu.Unlock()
     return nil
 }
 
 // SendPacketUpdates sends packet updates to neighboring links
 func (r *Router) SendPacketUpdates() error {
-    packet, err := r.preparePacket()
+    r.mu.Lock()
+    r.engine.Periodic()
+    neighbors := r.linkedNeighbors()
+
+    // The full table is going out, so there's nothing left for a
+    // triggered update to send
+    for _, server := range r.table {
+        server.changed = false
+    }
+    r.dirty = false
+    r.mu.Unlock()
+
+    for id, bindy := range neighbors {
+        // Each neighbor gets its own packet, so that split horizon can
+        // leave out or poison the routes we learned from it
+        packet, err := r.preparePacket(id, nil)
+        if err != nil {
+            return err
+        }
+
+        // Create a new client connection and send the packet
+        c, err := r.newClient(bindy)
+        if err != nil {
+            return errors.Wrapf(err, "r.sendUpdates: failed to send updates to neighbor %d - bindy: %s", id, bindy)
+        }
+
+        c.SendPacket(packet, r.log)
+    }
+    return nil
+}
+
+// controlMessage returns a message with only the entry to reach ourself,
+// for the hellos and probes that go in front of it. The router's lock must
+// be held.
+func (r *Router) controlMessage() *message.Message {
+    self := r.table[r.ID]
+    ip := net.ParseIP(self.IP)
+    return &message.Message{
+        Updates: 1,
+        Port: uint16(self.port),
+        IP: ip,
+        N: []message.Neighbor{
+            {IP: ip, Port: uint16(self.port), ID: r.ID, Cost: 0},
+        },
+    }
+}
+
+// sendControl sends a hello or probe message to a neighbor
+func (r *Router) sendControl(bindy string, msg *message.Message) error {
+    packet, err := r.marshal(msg)
     if err != nil {
-        return err
+        return errors.Wrapf(err, "r.sendControl: failed to marshal message for %s", bindy)
     }
 
-    r.mu.Lock()
-    defer r.mu.Unlock()
+    c, err := r.newClient(bindy)
+    if err != nil {
+        return errors.Wrapf(err, "r.sendControl: failed to create client for %s", bindy)
+    }
+    c.SendPacket(packet, r.log)
+    return nil
+}
 
+// linkedNeighbors returns the bind addresses of the neighbors we're
+// directly linked to, making sure we don't send packets to ourself.
+// Neighbors we haven't heard from in a while are included, so that
+// they can find us again when they come back.
+func (r *Router) linkedNeighbors() map[uint16]string {
+    neighbors := make(map[uint16]string, len(r.table))
     for id, server := range r.table {
-        if server.linkCost != Inf && server.linkCost != 0 {
-            bindy := r.table[id].bindy
-
-            // Make sure we don't send packets we're not directly linked
-            // to, or to ourself
-            if server.directCost == Inf || server.directCost == 0 {
-                continue
-            }
-
-            // Create a new client connection and send the packet
-            c, err := client.NewClient(bindy)
-            if err != nil {
-                return errors.Wrapf(err, "r.sendUpdates: failed to send updates to neighbor %d - bindy: %s", id, bindy)
-            }
-
-            c.SendPacket(packet, r.log)
+        if id == r.ID || !r.reachable(server.directCost) {
+            continue
         }
+        neighbors[id] = server.bindy
     }
-    return nil
+    return neighbors
 }
 
 // SendPacket handles sending a packet update to a single server
@@ -159,17 +293,29 @@ func (r *Router) SendPacket(packet []byte, src, dst uint16) error {
         return errors.Wrapf(SendErr, "r.SendPacket: failed to send packet")
     }
 
-    server := r.table[dst]
+    server, ok := r.table[dst]
+    if !ok {
+        return errors.Wrapf(RouteErr, "r.SendPacket: failed to send packet to %d", dst)
+    }
     bindy := server.bindy
 
     // Is our direct cost to the destination inf?
-    if server.directCost == Inf {
-        // Get the nexthop server's bind address then
-        bindy = r.table[server.nextHop].bindy
+    if !r.reachable(server.directCost) {
+        // Get the nexthop server's bind address then, spreading flows
+        // over equal cost next hops
+        rt, ok := r.route(dst)
+        if !ok {
+            return errors.Wrapf(RouteErr, "r.SendPacket: failed to send packet to %d", dst)
+        }
+        hop, ok := r.table[flowHop(rt, src, dst)]
+        if !ok {
+            return errors.Wrapf(RouteErr, "r.SendPacket: failed to send packet to %d", dst)
+        }
+        bindy = hop.bindy
     }
 
     // Create a new client connection and send the packet
-    c, err := client.NewClient(bindy)
+    c, err := r.newClient(bindy)
     if err != nil {
         return errors.Wrapf(err, "r.SendPacket: failed to send packet to neighbor %d", server.ID)
     }
@@ -179,57 +325,48 @@ func (r *Router) SendPacket(packet []byte, src, dst uint16) error {
     return nil
 }
 
-// preparePacket prepares an update packet
-func (r *Router) preparePacket() ([]byte, error) {
-    r.mu.Lock()
-
-    neighbors := r.table
-    numUpdates := len(neighbors)
-
-    // Create a new update message
-    updateMsg := &message.Message{
-        Updates: uint16(numUpdates),
-        Port:    uint16(neighbors[r.ID].port),
-        IP:      neighbors[r.ID].IP,
+// preparePacket prepares an update packet for the neighbor with the given ID.
+// If changed isn't nil, only those destinations are included, along with
+// our own entry and the neighbor's, which it needs to identify us and the
+// cost of our link, and the update is marked partial.
+func (r *Router) preparePacket(to uint16, changed map[uint16]bool) ([]byte, error) {
+    // The assignment's format can't mark an update as partial, so in
+    // legacy mode the whole table goes out every time
+    if r.legacy {
+        changed = nil
     }
-    r.mu.Unlock()
 
-    // Create a new map for our update message neighbors to go into
-    var un map[uint16]*message.Neighbor
-    un = make(map[uint16]*message.Neighbor, numUpdates)
-
-    // Create update neighbors for each neighbor our server has
-    // and one for our server itself.
-    var i uint16 = 1
-	for ; i <= uint16(NumServers); i++ {
-        // Let's get the neighbors information
-        n := neighbors[i]
-
-        // Create a new mNeighbor
-        updateNeighbor := message.Neighbor{
-            IP:   n.IP,
-            Port: uint16(n.port),
-            ID:   n.ID,
-            Cost: uint16(n.linkCost),
-        }
+    r.mu.Lock()
 
-        // Uncomment this line to see how the update neighbor is formatted
-        //r.log.OutDebug("Update neighbor: %+v\n", updateNeighbor)
+    // Get the update message from the routing engine
+    updateMsg := r.engine.Advertisement(to)
+    updateMsg.Port = uint16(r.table[r.ID].port)
+    updateMsg.IP = net.ParseIP(r.table[r.ID].IP)
+    updateMsg.Partial = changed != nil
+
+    // Fill in the address of each update neighbor, keeping the entries
+    // that go out at the front
+    entries := updateMsg.N[:0]
+    for _, un := range updateMsg.N {
+        id := un.ID
+        n, ok := r.table[id]
+        if !ok || (changed != nil && !changed[id] && id != r.ID && id != to) {
+            continue
+        }
+        un.IP = net.ParseIP(n.IP)
+        un.Port = uint16(n.port)
+        entries = append(entries, un)
 
         // Formatting looks like this --
         // Update neighbor: {nIP:192.168.0.104 nPort:2000 nID:1 nCost:7}
-
-        // Add the neighbor to the update neighbor map
-        un[n.ID] = &updateNeighbor
     }
+    updateMsg.N = entries
+    r.mu.Unlock()
 
-    //r.log.OutDebug("neighbors? %+v\n", un)
-
-    // Set the update message neighbors map equal to our update neighbor map
-    updateMsg.N = un
+    updateMsg.Updates = uint16(len(updateMsg.N))
 
     // Marshal the message into a packet to be sent
-    packet, err := updateMsg.Marshal()
+    packet, err := r.marshal(updateMsg)
     if err != nil {
         e := errors.Wrapf(err, "failed to marshal update message %+v", updateMsg)
         return packet, e
@@ -241,51 +378,62 @@ func (r *Router) preparePacket() ([]byte, error) {
     return packet, nil
 }
 
+// marshal marshals a message, in the assignment's format in legacy mode
+// and with a checksum if we're adding them
+func (r *Router) marshal(msg *message.Message) ([]byte, error) {
+    if r.legacy {
+        return msg.MarshalLegacy()
+    }
+    msg.Checksum = r.checksum
+    return msg.Marshal()
+}
+
 // createDifferentSenderPacket(senderID, neighborID) marshals
 // a new message using the first servers information in the sender
 // bytes & the second servers information in the the next set of bytes
-func (r *Router) createDifferentSenderPacket(senderID, neighborID uint16, newCost int) ([]byte, error) {
+func (r *Router) createDifferentSenderPacket(senderID, neighborID uint16, newCost int, m metric.Link) ([]byte, error) {
     r.mu.Lock()
     defer r.mu.Unlock()
 
-    sender := r.table[senderID]
+    sender, ok := r.table[senderID]
+    if !ok {
+        return nil, errors.Wrapf(RouteErr, "unknown sender %d", senderID)
+    }
 
     // Create a new update message
     updateMsg := &message.Message{
+        Type:    message.TypeLinkCost,
         Updates: uint16(1),
         Port:    uint16(sender.port),
-        IP:      sender.IP,
+        IP:      net.ParseIP(sender.IP),
     }
 
-    // Create a new map for our update message neighbors to go into
-    var un map[uint16]*message.Neighbor
-    un = make(map[uint16]*message.Neighbor, 1)
-
     // Create an update neighbors for the neighbor
-    neighbor := r.table[neighborID]
+    neighbor, ok := r.table[neighborID]
+    if !ok {
+        return nil, errors.Wrapf(RouteErr, "unknown server %d", neighborID)
+    }
 
     // Create a new mNeighbor
-    updateNeighbor := &message.Neighbor{
-        IP:   neighbor.IP,
+    updateNeighbor := message.Neighbor{
+        IP:   net.ParseIP(neighbor.IP),
         Port: uint16(neighbor.port),
         ID:   neighbor.ID,
-        Cost: uint16(newCost),
+        Cost: uint16(r.metric(newCost)),
+        Metrics: m,
     }
 
     // Uncomment this line to see how the update neighbor is formatted
-    //r.log.OutServer("Update neighbor: %+v\n", *updateNeighbor)
+    //r.log.OutServer("Update neighbor: %+v\n", updateNeighbor)
 
     // Formatting looks like this --
     // Update neighbor: {nIP:192.168.0.104 nPort:2000 nID:1 nCost:7}
 
-    // Add the neighbor to the update neighbor map
-    un[neighbor.ID] = updateNeighbor
-
-    // Set the update message neighbors map equal to our update neighbor map
-    updateMsg.N = un
+    // The neighbor is the message's only entry
+    updateMsg.N = []message.Neighbor{updateNeighbor}
 
     // Marshal the message into a packet to be sent
-    packet, err := updateMsg.Marshal()
+    packet, err := r.marshal(updateMsg)
     if err != nil {
         e := errors.Wrapf(err, "failed to marshal update message %+v", updateMsg)
         return packet, e
@@ -296,50 +444,19 @@ func (r *Router) createDifferentSenderPacket(senderID, neighborID uint16, newCos
     return packet, nil
 }
 
-// checkForwarding checks to see if a new packet should be forwarded
-func (r *Router) checkForwarding(senderID uint16, packet []byte) bool {
+// checkForwarding forwards a link cost update meant for another server on
+// towards it
+func (r *Router) checkForwarding(packet []byte, senderID, dest uint16) bool {
     r.mu.Lock()
-    defer r.mu.Unlock()
-
-    forwarded := false
-
-    r.network.mu.Lock()
-    // Get the router for this sender from the network
-    router := r.network.Routers[senderID]
-    r.network.mu.Unlock()
-
-    router.mu.Lock()
-    for dest, server := range router.table {
-        server.mu.Lock()
-
-        // Is the nextHop our router & we're not the destination?
-        if server.nextHop == r.ID && server.ID != r.ID {
-            forwarded := server.forwarded
-
-            // Make sure we haven't *JUST* forwarded a packet to this server
-            now := time.Now()
-            tenSecAgo := now.Add(-10*time.Second)
-            if forwarded.Before(tenSecAgo) {
-                r.table[server.ID].forwarded = time.Now()
-                r.forwardPacket(packet, r.table[dest].bindy, dest)
-                //r.log.OutDebug("\nFORWARDED PACKET FROM %d TO %d\n", senderID, dest)
-            }
-        }
-        server.mu.Unlock()
+    _, ok := r.table[dest]
+    r.mu.Unlock()
+    if !ok {
+        return false
     }
-    router.mu.Unlock()
-    
-    return forwarded
-}
 
-// forwardPacket handles forwarding the packet to the other server
-func (r *Router) forwardPacket(packet []byte, bindy string, id uint16) error {
-    // Create a new client connection and send the packet
-    c, err := client.NewClient(bindy)
-    if err != nil {
-        return errors.Wrapf(err, "r.forwardPacket: failed to forward packet to neighbor %d", id)
+    if err := r.SendPacket(packet, senderID, dest); err != nil {
+        r.log.OutError("\nr.checkForwarding: failed to forward packet from %d to %d - %+v\n", senderID, dest, err)
+        return false
     }
-
-    c.SendPacket(packet, r.log)
-    return nil
+    return true
 }
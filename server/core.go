@@ -3,8 +3,10 @@ package server
 
 import (
 	"dvr/log"
+	"dvr/metric"
 	"dvr/types"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/pkg/errors"
@@ -13,7 +15,7 @@ import (
 // func New {{{
 
 // New initializes and returns a new Server.
-func New(packetChan chan []byte, id uint16, bindy string, router types.Router, l *log.Logger) *Server {
+func New(packetChan chan types.Packet, id uint16, bindy string, router types.Router, l *log.Logger) *Server {
 	s := Server{
 		ID:  id,
 		active: true,
@@ -79,6 +81,11 @@ func (s *Server) Update(id1, id2 uint16, newCost int) error {
     return s.router.Update(id1,id2,newCost)
 }
 
+// UpdateMetrics sets some of the attributes of the link between two neighbors
+func (s *Server) UpdateMetrics(id1, id2 uint16, m metric.Link) error {
+	return s.router.UpdateMetrics(id1, id2, m)
+}
+
 // Step sends the routing update immediately, instead of waiting for the update interval
 func (s *Server) Step() error {
 	if !s.active {
@@ -93,7 +100,7 @@ func (s *Server) Step() error {
 }
 
 // Packets prints the number of packets the server has received since the last time
-// this function was called.
+// this function was called, and how many from each neighbor failed their checksum.
 func (s *Server) Packets() error {
 	s.mu.Lock()
 	packets := s.packets
@@ -101,6 +108,20 @@ func (s *Server) Packets() error {
 	s.mu.Unlock()
 
 	s.log.OutServer("Number of packets received since last call: %d\n", packets)
+
+	failures := s.router.ChecksumFailures()
+	ids := make([]int, 0, len(failures))
+	for id := range failures {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		if id == 0 {
+			s.log.OutServer("Packets from unknown servers that failed their checksum: %d\n", failures[0])
+			continue
+		}
+		s.log.OutServer("Packets from server %d that failed their checksum: %d\n", id, failures[uint16(id)])
+	}
 	return nil
 }
 
@@ -110,11 +131,23 @@ func (s *Server) Display() error {
 	return nil
 }
 
+// DisplayState displays every route in the routing table along with
+// its state and timer.
+func (s *Server) DisplayState() error {
+	s.router.DisplayState()
+	return nil
+}
+
 // Disable disables the link between this server and another
 func (s *Server) Disable(id uint16) error {
     return s.router.Disable(id)
 }
 
+// Reload parses the topology file again and applies what changed
+func (s *Server) Reload() error {
+	return s.router.Reload()
+}
+
 // Crash simulates a server crashing
 func (s *Server) Crash() error {
 	s.log.OutServer("Crashing server now .. bye!\n")
@@ -123,6 +156,7 @@ func (s *Server) Crash() error {
 		// Closing s.bye will cause the s.Listen and the s.Loopy goroutines to stop
 		close(s.bye)
 		s.active = false
+		s.router.Stop()
 	}
 	s.mu.Unlock()
 	return nil
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"dvr/types"
 	"log"
 	"net"
 )
@@ -33,6 +34,11 @@ func (s *Server) Listen() error {
 		s.mu.Unlock()
 	}()
 
+	// Packets go out from our listener too, so the address they come from
+	// tells us who sent them
+	conn := s.listener.(*net.UDPConn)
+	s.router.Bind(conn)
+
 	errChan := make(chan error, 1)
 	buffer := make([]byte, maxBufferSize)
 
@@ -46,7 +52,7 @@ func (s *Server) Listen() error {
 			//
 			// Whenever new packets arrive, `buffer` gets filled and we can continue
 			// the execution.
-			n, _, err := s.listener.ReadFrom(buffer)
+			n, from, err := conn.ReadFromUDP(buffer)
 			if err != nil {
 				// An error occurred so let's send it to our
 				// error channel and return
@@ -58,8 +64,11 @@ func (s *Server) Listen() error {
 			s.packets++
 			s.mu.Unlock()
 
-			packet := buffer[:n]
-			s.packetChan <- packet
+			// The buffer is reused for the next read, so the router
+			// gets its own copy of the packet
+			packet := make([]byte, n)
+			copy(packet, buffer[:n])
+			s.packetChan <- types.Packet{Data: packet, From: from}
 		}
 	}()
 
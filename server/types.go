@@ -44,5 +44,5 @@ type Server struct {
 	active bool 
 
 	// Channel that we'll send incoming packets on
-	packetChan chan []byte
+	packetChan chan types.Packet
 } // }}}
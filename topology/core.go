@@ -3,77 +3,124 @@ package topology
 
 import (
     "bufio"
+    "dvr/message"
+    "dvr/metric"
     "fmt"
     "log"
     "net"
     "os"
+    "path/filepath"
     "strconv"
     "strings"
 
     "github.com/pkg/errors"
 )
-var inf int = 99999
 
-// ParseTopology parses the provided topology file and returns the topology setup
-func ParseTopology(file string) (*Topology, uint16, error) {
-	var t Topology
-	t.Servers = make(map[int]*Server, 4)
+// Inf is the link cost given to servers we don't have a link to. It is
+// at least as large as any infinity metric the network can be run with.
+const Inf int = message.MaxCost
 
-	var sid uint16
+// ParseTopology parses the provided topology file and returns the topology
+// setup. The format is picked by the file's extension, .json files are JSON
+// and anything else is the text format.
+//
+// The ID of our own server, if it isn't 0, lets a single file describe the
+// whole network. Our neighbors are then the servers at the other end of the
+// links we're at one end of.
+func ParseTopology(file string, self uint16) (*Topology, uint16, error) {
+    switch strings.ToLower(filepath.Ext(file)) {
+    case ".json":
+        return parseJSON(file, self)
+    case ".yaml", ".yml":
+        return nil, 0, errors.Errorf("ParseTopologyFile: YAML topology files aren't supported, use JSON")
+    }
+    return parseText(file, self)
+}
 
-	// Open the file
-	f, err := os.Open(file)
-	if err != nil {
-		log.Fatalf("Error opening topology file! %v", err)
-	}
-	defer f.Close()
-
-	// Create a new bufio scanner so we can read line by line
-	scanner := bufio.NewScanner(f)
-	line := 1
-	for scanner.Scan() {
-		switch line {
-		case 1:
-			numServers, err := strconv.Atoi(scanner.Text())
-			if err != nil {
-				return &t, sid, err
-			}
-			t.NumServers = numServers
-			line++
-			break
-		case 2:
-			numNeighbors, err := strconv.Atoi(scanner.Text())
-			if err != nil {
-				return &t, sid, err
-			}
-			t.NumNeighbors = numNeighbors
-			line++
-			break
-		case 3:
-			fallthrough
-		case 4:
-			fallthrough
-		case 5:
-			fallthrough
-		case 6:
-            text := scanner.Text()
-            textArr := strings.Split(text, " ")
-            if len(textArr) != 3 {
-                e := errors.Errorf("ParseTopologyFile: error parsing topology file, incorrect number of arguments in line %d", line)
+// parseText parses a text topology file
+//
+// The file starts with the number of servers and the number of neighbors,
+// followed by that many server lines and then that many link lines. Blank
+// lines are skipped, and the infinity metric may be given on its own line
+// anywhere in the file.
+func parseText(file string, self uint16) (*Topology, uint16, error) {
+    var t Topology
+    t.Servers = make(map[int]*Server, 4)
+
+    var sid uint16
+
+    // Open the file
+    f, err := os.Open(file)
+    if err != nil {
+        log.Fatalf("Error opening topology file! %v", err)
+    }
+    defer f.Close()
+
+    // Create a new bufio scanner so we can read line by line, counting
+    // the header, server and link lines we've read so far
+    scanner := bufio.NewScanner(f)
+    line := 0
+    header, servers, links := 0, 0, 0
+    for scanner.Scan() {
+        line++
+        textArr := strings.Fields(scanner.Text())
+        if len(textArr) == 0 {
+            continue
+        }
+
+        // The infinity metric may be given on its own line anywhere in
+        // the file, as `infinity <metric>`
+        if textArr[0] == "infinity" {
+            if len(textArr) != 2 {
+                e := errors.Errorf("ParseTopologyFile: error parsing topology file, incorrect number of arguments in infinity line %d", line)
+                return &t, sid, e
+            }
+            metric, err := strconv.Atoi(textArr[1])
+            if err != nil || metric < 1 || metric > message.MaxCost {
+                e := errors.Errorf("ParseTopologyFile: error parsing topology file, infinity must be an integer between 1 and %d in line %d", message.MaxCost, line)
                 return &t, sid, e
             }
+            t.Infinity = metric
+            continue
+        }
 
-            tid, err := strconv.Atoi(textArr[0])
+        switch {
+        case header == 0:
+            numServers, err := parseCount(textArr, "number of servers", line)
             if err != nil {
-                e := errors.Errorf("s.ParseTopologyFile: error parsing topology file, non integer in first column of line %d", line)
+                return &t, sid, err
+            }
+            t.NumServers = numServers
+            header++
+        case header == 1:
+            numNeighbors, err := parseCount(textArr, "number of neighbors", line)
+            if err != nil {
+                return &t, sid, err
+            }
+            t.NumNeighbors = numNeighbors
+            header++
+        case servers < t.NumServers:
+            if len(textArr) != 2 && len(textArr) != 3 {
+                e := errors.Errorf("ParseTopologyFile: error parsing topology file, incorrect number of arguments in server line %d", line)
                 return &t, sid, e
             }
-            id := uint16(tid)
 
-            portS := textArr[2]
-            port, err := strconv.Atoi(portS)
+            id, err := parseID(textArr[0], "first", line)
+            if err != nil {
+                return &t, sid, err
+            }
+            if _, ok := t.Servers[int(id)]; ok {
+                e := errors.Errorf("ParseTopologyFile: error parsing topology file, server %d is listed twice, again in line %d", id, line)
+                return &t, sid, e
+            }
+
+            host, portS, err := parseAddress(textArr[1:], line)
             if err != nil {
-                e := errors.Errorf("s.ParseTopologyFile: error parsing topology file, non integer in port field of line %d", line)
+                return &t, sid, err
+            }
+            port, err := strconv.Atoi(portS)
+            if err != nil || port < 1 || port > 65535 {
+                e := errors.Errorf("ParseTopologyFile: error parsing topology file, port must be an integer between 1 and 65535 in line %d", line)
                 return &t, sid, e
             }
             /* Project specification part 3.1 Topology Establishment
@@ -86,85 +133,188 @@ func ParseTopology(file string) (*Topology, uint16, error) {
                Calling GetOutboundIP right here makes it so we don't have to
                do that at all. If this this is the first server (i.e. host),
                then let's set the ip to the outbound ip of the machine
+
+               The outbound ip is always an IPv4 address, so IPv6 addresses
+               are used as they're given.
             */
-            ip := GetOutboundIP(portS)
+            ip := host
+            if net.ParseIP(host).To4() != nil {
+                ip = GetOutboundIP(portS)
+            }
 
             n := Server{
                 ID:    id,
                 IP:    ip,
                 Port:  port,
-                Bindy: ip + ":" + portS,
-                Cost:  inf,
-            }
-
-            t.Servers[tid] = &n
-            line++
-            break
-        case 7:
-            text := scanner.Text()
-            textArr := strings.Split(text, " ")
-            if len(textArr) != 3 {
-                e := errors.Errorf("ParseTopologyFile: error parsing topology file, incorrect number of arguments in line %d", line)
-                return &t, sid, e
+                Bindy: net.JoinHostPort(ip, portS),
+                Cost:  Inf,
             }
 
-            id1, err := strconv.Atoi(textArr[0])
-            if err != nil {
-                e := errors.Errorf("s.ParseTopologyFile: error parsing topology file, non integer in first column of line %d", line)
+            t.Servers[int(id)] = &n
+            servers++
+        case links < t.NumNeighbors:
+            if len(textArr) < 3 {
+                e := errors.Errorf("ParseTopologyFile: error parsing topology file, incorrect number of arguments in link line %d", line)
                 return &t, sid, e
             }
 
-            sid = uint16(id1)
-
-            id2, err := strconv.Atoi(textArr[1])
+            id1, err := parseID(textArr[0], "first", line)
             if err != nil {
-                e := errors.Errorf("s.ParseTopologyFile: error parsing topology file, non integer in first column of line %d", line)
-                return &t, sid, e
+                return &t, sid, err
             }
-
-            cost, err := strconv.Atoi(textArr[2])
+            id2, err := parseID(textArr[1], "second", line)
             if err != nil {
-                e := errors.Errorf("s.ParseTopologyFile: error parsing topology file, non integer in first column of line %d", line)
-                return &t, sid, e
+                return &t, sid, err
             }
 
-            if _, ok := t.Servers[id2]; ok {
-                t.Servers[id2].Cost = cost
-            }
-            line++
-            break
-        default:
-            text := scanner.Text()
-            textArr := strings.Split(text, " ")
-            if len(textArr) != 3 {
-                e := errors.Errorf("ParseTopologyFile: error parsing topology file, incorrect number of arguments in line %d", line)
-                return &t, sid, e
-            }
-            // I could care less about the server id in textArr[0]
-            id, err := strconv.Atoi(textArr[1])
+            cost, metrics, err := parseLink(textArr[2:], line)
             if err != nil {
-                e := errors.Errorf("s.ParseTopologyFile: error parsing topology file, non integer in first column of line %d", line)
-                return &t, sid, e
+                return &t, sid, err
             }
 
-            cost, err := strconv.Atoi(textArr[2])
-            if err != nil {
-                e := errors.Errorf("s.ParseTopologyFile: error parsing topology file, non integer in first column of line %d", line)
-                return &t, sid, e
+            for _, id := range []uint16{id1, id2} {
+                if _, ok := t.Servers[int(id)]; !ok {
+                    e := errors.Errorf("ParseTopologyFile: error parsing topology file, server %d in line %d isn't in the server lines", id, line)
+                    return &t, sid, e
+                }
             }
+            links++
 
-            if _, ok := t.Servers[id]; ok {
-                t.Servers[id].Cost = cost
+            // Without an ID, the first link line tells us which server
+            // we are, after that I could care less about the server id
+            // in the first column. With one, the file is shared by the
+            // whole network and only the links we're at one end of are
+            // ours.
+            other := id2
+            if self == 0 {
+                if links == 1 {
+                    sid = id1
+                }
+            } else if id1 == self {
+                other = id2
+            } else if id2 == self {
+                other = id1
+            } else {
+                continue
             }
 
-            line++
-            break
+            server := t.Servers[int(other)]
+            server.Cost = cost
+            server.Metrics = metrics
+        default:
+            e := errors.Errorf("ParseTopologyFile: error parsing topology file, line %d is past the %d server and %d link lines", line, t.NumServers, t.NumNeighbors)
+            return &t, sid, e
+        }
+    }
+    if err := scanner.Err(); err != nil {
+        return &t, sid, errors.Wrapf(err, "ParseTopologyFile: error reading topology file")
+    }
+
+    if header < 2 || servers < t.NumServers || links < t.NumNeighbors {
+        e := errors.Errorf("ParseTopologyFile: error parsing topology file, expected %d server and %d link lines but found %d and %d", t.NumServers, t.NumNeighbors, servers, links)
+        return &t, sid, e
+    }
+    if self != 0 {
+        sid = self
+    }
+    if _, ok := t.Servers[int(sid)]; !ok && (t.NumNeighbors > 0 || self != 0) {
+        e := errors.Errorf("ParseTopologyFile: error parsing topology file, our server %d isn't in the server lines", sid)
+        return &t, sid, e
+    }
+
+    // A server started on its own, to discover its neighbors, has no
+    // links to tell us which server it is
+    if sid == 0 && len(t.Servers) == 1 {
+        for _, server := range t.Servers {
+            sid = server.ID
         }
     }
 
     return &t, sid, nil
 }
 
+// parseCount parses a header line, which holds a single count
+func parseCount(fields []string, what string, line int) (int, error) {
+    if len(fields) != 1 {
+        return 0, errors.Errorf("ParseTopologyFile: error parsing topology file, expected the %s alone in line %d", what, line)
+    }
+    count, err := strconv.Atoi(fields[0])
+    if err != nil || count < 0 {
+        return 0, errors.Errorf("ParseTopologyFile: error parsing topology file, the %s must be a non negative integer in line %d", what, line)
+    }
+    return count, nil
+}
+
+// parseID parses a server ID, which can be anything that fits in a message
+// except 0, since 0 stands for no server
+func parseID(field, column string, line int) (uint16, error) {
+    id, err := strconv.ParseUint(field, 10, 16)
+    if err != nil || id == 0 {
+        return 0, errors.Errorf("ParseTopologyFile: error parsing topology file, server ID in %s column of line %d must be an integer between 1 and 65535", column, line)
+    }
+    return uint16(id), nil
+}
+
+// parseLink parses the rest of a link line after the two server IDs, which
+// is a link cost, link attributes like delay=20 bw=100, or both. When there
+// are attributes, the router works out the cost from them instead.
+func parseLink(fields []string, line int) (int, metric.Link, error) {
+    var cost int
+    if !metric.IsAttr(fields[0]) {
+        c, err := strconv.Atoi(fields[0])
+        if err != nil {
+            e := errors.Errorf("s.ParseTopologyFile: error parsing topology file, non integer in third column of line %d", line)
+            return 0, metric.Link{}, e
+        }
+        cost = c
+        fields = fields[1:]
+    }
+
+    metrics, err := metric.Parse(fields)
+    if err != nil {
+        e := errors.Wrapf(err, "s.ParseTopologyFile: error parsing topology file, bad link attributes in line %d", line)
+        return 0, metric.Link{}, e
+    }
+    return cost, metrics, nil
+}
+
+// parseAddress parses the address in a server line, which is either an IP
+// address and a port, or a bind address like 192.168.0.104:2000 or
+// [::1]:2000, returning the IP address and the port
+func parseAddress(fields []string, line int) (string, string, error) {
+    host, port := "", ""
+    if len(fields) == 1 {
+        var err error
+        host, port, err = net.SplitHostPort(fields[0])
+        if err != nil {
+            e := errors.Errorf("ParseTopologyFile: error parsing topology file, '%s' is not an address like 192.168.0.104:2000 or [::1]:2000 in line %d", fields[0], line)
+            return "", "", e
+        }
+    } else {
+        host, port = fields[0], fields[1]
+    }
+
+    ip, ok := parseIP(host)
+    if !ok {
+        e := errors.Errorf("ParseTopologyFile: error parsing topology file, '%s' is not an IP address in line %d", host, line)
+        return "", "", e
+    }
+    return ip, port, nil
+}
+
+// parseIP parses an IP address, which may be in brackets like [::1],
+// returning it in its usual form
+func parseIP(s string) (string, bool) {
+    if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+        s = s[1 : len(s)-1]
+    }
+    ip := net.ParseIP(s)
+    if ip == nil {
+        return "", false
+    }
+    return ip.String(), true
+}
+
 // GetOutboundIP gets the preferred outbound ip of this machine
 // src: https://stackoverflow.com/a/37382208
 func GetOutboundIP(port string) string {
@@ -177,8 +327,5 @@ func GetOutboundIP(port string) string {
 	defer conn.Close()
 
 	localAddr := conn.LocalAddr().(*net.UDPAddr)
-
-	ip := fmt.Sprintf("%v", localAddr.IP)
-	ipArr := strings.Split(ip, ":")
-	return ipArr[0]
+	return localAddr.IP.String()
 }
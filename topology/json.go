@@ -0,0 +1,417 @@
+package topology
+
+import (
+    "bytes"
+    "dvr/message"
+    "dvr/metric"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strconv"
+    "strings"
+
+    "github.com/pkg/errors"
+)
+
+// A JSON topology file looks like:
+//
+//  {
+//      "self": 1,
+//      "infinity": 16,
+//      "interval": 60,
+//      "servers": [
+//          {"id": 1, "name": "alpha", "ip": "192.168.0.104", "port": 2000},
+//          {"id": 2, "port": 2001}
+//      ],
+//      "links": [
+//          {"from": 1, "to": 2, "cost": 7},
+//          {"from": 1, "to": 3, "delay": 20, "bw": 100, "loss": 1}
+//      ]
+//  }
+//
+// Self is optional in a file shared by the whole network, where each server
+// is given its ID with -id instead, but one of them is needed. Servers
+// without an IP use the outbound IP of the machine, like the text format
+// does. Links go both ways, so listing one the other way around as well is
+// only allowed with the same cost and attributes. Links that don't have
+// self at one end are checked, but don't change our neighbors.
+
+// jsonServer is a server in a JSON topology file
+type jsonServer struct {
+    ID int `json:"id"`
+    Name string `json:"name"`
+    IP string `json:"ip"`
+    Port int `json:"port"`
+}
+
+// jsonLink is a link in a JSON topology file, with a cost, attributes or
+// both
+type jsonLink struct {
+    From int `json:"from"`
+    To int `json:"to"`
+    Cost *int `json:"cost"`
+    Delay *int `json:"delay"`
+    Bandwidth *int `json:"bw"`
+    Loss *int `json:"loss"`
+}
+
+// jsonElement is a server or link, and where it starts in the file
+type jsonElement struct {
+    raw json.RawMessage
+    at int
+}
+
+// jsonParser parses a JSON topology file, keeping the file around so that
+// errors can say which line and column they're at
+type jsonParser struct {
+    file string
+    data []byte
+}
+
+// parseJSON parses a JSON topology file
+func parseJSON(file string, id uint16) (*Topology, uint16, error) {
+    var t Topology
+    t.Servers = make(map[int]*Server, 4)
+
+    data, err := os.ReadFile(file)
+    if err != nil {
+        return &t, 0, errors.Wrapf(err, "ParseTopologyFile: error reading topology file")
+    }
+    p := jsonParser{file: filepath.Base(file), data: data}
+
+    // Walk the top level object ourselves, so that we know where each
+    // server and link starts
+    dec := json.NewDecoder(bytes.NewReader(data))
+    if err := p.expect(dec, json.Delim('{')); err != nil {
+        return &t, 0, err
+    }
+
+    self, selfAt := 0, -1
+    var servers, links []jsonElement
+    for dec.More() {
+        keyAt := p.skip(int(dec.InputOffset()))
+        tok, err := dec.Token()
+        if err != nil {
+            return &t, 0, p.syntaxError(err)
+        }
+        key, _ := tok.(string)
+        at := p.skip(int(dec.InputOffset()))
+
+        switch key {
+        case "self":
+            selfAt = at
+            if err := p.decodeInt(dec, &self, key, at); err != nil {
+                return &t, 0, err
+            }
+        case "infinity":
+            if err := p.decodeInt(dec, &t.Infinity, key, at); err != nil {
+                return &t, 0, err
+            }
+            if t.Infinity < 1 || t.Infinity > message.MaxCost {
+                return &t, 0, p.errorf(at, "infinity", "must be an integer between 1 and %d", message.MaxCost)
+            }
+        case "interval":
+            if err := p.decodeInt(dec, &t.Interval, key, at); err != nil {
+                return &t, 0, err
+            }
+            if t.Interval < 1 {
+                return &t, 0, p.errorf(at, "interval", "must be at least 1 second")
+            }
+        case "servers":
+            if servers, err = p.decodeArray(dec, key); err != nil {
+                return &t, 0, err
+            }
+        case "links":
+            if links, err = p.decodeArray(dec, key); err != nil {
+                return &t, 0, err
+            }
+        default:
+            return &t, 0, p.errorf(keyAt, key, "unknown field")
+        }
+    }
+    if err := p.expect(dec, json.Delim('}')); err != nil {
+        return &t, 0, err
+    }
+
+    // Servers
+    serverAt := make(map[int]int, len(servers))
+    names := make(map[string]int, len(servers))
+    for i, el := range servers {
+        var s jsonServer
+        field := fmt.Sprintf("servers[%d]", i)
+        if err := p.unmarshal(el, field, &s); err != nil {
+            return &t, 0, err
+        }
+
+        if s.ID < 1 || s.ID > 65535 {
+            return &t, 0, p.errorf(p.fieldAt(el, "id"), field + ".id", "must be an integer between 1 and 65535")
+        }
+        if prev, ok := serverAt[s.ID]; ok {
+            line, _ := p.position(prev)
+            return &t, 0, p.errorf(p.fieldAt(el, "id"), field + ".id", "duplicate server ID %d, already listed at line %d", s.ID, line)
+        }
+        if s.Name != "" {
+            if prev, ok := names[s.Name]; ok {
+                line, _ := p.position(prev)
+                return &t, 0, p.errorf(p.fieldAt(el, "name"), field + ".name", "duplicate server name '%s', already used at line %d", s.Name, line)
+            }
+            names[s.Name] = el.at
+        }
+        if s.Port < 1 || s.Port > 65535 {
+            return &t, 0, p.errorf(p.fieldAt(el, "port"), field + ".port", "must be an integer between 1 and 65535")
+        }
+
+        portS := strconv.Itoa(s.Port)
+        ip := s.IP
+        if ip == "" {
+            ip = GetOutboundIP(portS)
+        } else if parsed, ok := parseIP(ip); ok {
+            ip = parsed
+        } else {
+            return &t, 0, p.errorf(p.fieldAt(el, "ip"), field + ".ip", "'%s' is not an IP address", ip)
+        }
+
+        serverAt[s.ID] = el.at
+        t.Servers[s.ID] = &Server{
+            ID: uint16(s.ID),
+            Name: s.Name,
+            IP: ip,
+            Port: s.Port,
+            Bindy: net.JoinHostPort(ip, portS),
+            Cost: Inf,
+        }
+    }
+    t.NumServers = len(t.Servers)
+
+    // Self, which the ID we were given takes priority over. A file shared
+    // by the whole network leaves it out, so we have to be given our ID.
+    switch {
+    case id != 0:
+        if _, ok := t.Servers[int(id)]; !ok {
+            return &t, 0, errors.Errorf("ParseTopologyFile: %s: server %d isn't in the servers", p.file, id)
+        }
+        self = int(id)
+    case selfAt != -1:
+        if _, ok := t.Servers[self]; !ok {
+            return &t, 0, p.errorf(selfAt, "self", "server %d isn't in the servers", self)
+        }
+    default:
+        return &t, 0, p.errorf(0, "self", "missing, the file has no entry for our server, give our server's ID with self or -id")
+    }
+    sid := uint16(self)
+
+    // Links
+    type seenLink struct {
+        at int
+        link jsonLink
+    }
+    seen := make(map[[2]int]seenLink, len(links))
+    for i, el := range links {
+        var l jsonLink
+        field := fmt.Sprintf("links[%d]", i)
+        if err := p.unmarshal(el, field, &l); err != nil {
+            return &t, 0, err
+        }
+
+        if _, ok := t.Servers[l.From]; !ok {
+            return &t, 0, p.errorf(p.fieldAt(el, "from"), field + ".from", "unknown server %d", l.From)
+        }
+        if _, ok := t.Servers[l.To]; !ok {
+            return &t, 0, p.errorf(p.fieldAt(el, "to"), field + ".to", "unknown server %d", l.To)
+        }
+        if l.From == l.To {
+            return &t, 0, p.errorf(p.fieldAt(el, "to"), field + ".to", "a server can't link to itself")
+        }
+
+        cost, metrics, err := l.parse()
+        if err != nil {
+            return &t, 0, p.errorf(el.at, field, "%s", err.Error())
+        }
+
+        if prev, ok := seen[[2]int{l.From, l.To}]; ok {
+            line, _ := p.position(prev.at)
+            return &t, 0, p.errorf(el.at, field, "duplicate link %d-%d, already listed at line %d", l.From, l.To, line)
+        }
+        if prev, ok := seen[[2]int{l.To, l.From}]; ok {
+            pc, pm, _ := prev.link.parse()
+            if pc != cost || pm != metrics {
+                line, _ := p.position(prev.at)
+                return &t, 0, p.errorf(el.at, field, "asymmetric link %d-%d, listed the other way around with a different cost or attributes at line %d", l.From, l.To, line)
+            }
+            continue
+        }
+        seen[[2]int{l.From, l.To}] = seenLink{at: el.at, link: l}
+
+        // Only our own links make neighbors
+        var other int
+        switch {
+        case l.From == self:
+            other = l.To
+        case l.To == self:
+            other = l.From
+        default:
+            continue
+        }
+        t.Servers[other].Cost = cost
+        t.Servers[other].Metrics = metrics
+        t.NumNeighbors++
+    }
+
+    return &t, sid, nil
+}
+
+// parse returns the cost and attributes of a link
+func (l jsonLink) parse() (int, metric.Link, error) {
+    attrs := make([]string, 0, 3)
+    if l.Delay != nil {
+        attrs = append(attrs, "delay=" + strconv.Itoa(*l.Delay))
+    }
+    if l.Bandwidth != nil {
+        attrs = append(attrs, "bw=" + strconv.Itoa(*l.Bandwidth))
+    }
+    if l.Loss != nil {
+        attrs = append(attrs, "loss=" + strconv.Itoa(*l.Loss))
+    }
+    if l.Cost == nil && len(attrs) == 0 {
+        return 0, metric.Link{}, errors.Errorf("link needs a cost or link attributes")
+    }
+
+    cost := 0
+    if l.Cost != nil {
+        cost = *l.Cost
+        if cost < 1 {
+            return 0, metric.Link{}, errors.Errorf("cost must be at least 1")
+        }
+    }
+    metrics, err := metric.Parse(attrs)
+    if err != nil {
+        return 0, metric.Link{}, err
+    }
+    return cost, metrics, nil
+}
+
+// expect reads the next token, which must be the given delimiter
+func (p *jsonParser) expect(dec *json.Decoder, d json.Delim) error {
+    at := p.skip(int(dec.InputOffset()))
+    tok, err := dec.Token()
+    if err != nil {
+        return p.syntaxError(err)
+    }
+    if tok != d {
+        return p.errorf(at, "", "expected '%s'", d)
+    }
+    return nil
+}
+
+// decodeInt decodes an integer field
+func (p *jsonParser) decodeInt(dec *json.Decoder, v *int, field string, at int) error {
+    if err := dec.Decode(v); err != nil {
+        if _, ok := err.(*json.UnmarshalTypeError); ok {
+            return p.errorf(at, field, "must be an integer")
+        }
+        return p.syntaxError(err)
+    }
+    return nil
+}
+
+// decodeArray decodes an array of objects, keeping where each one starts
+func (p *jsonParser) decodeArray(dec *json.Decoder, field string) ([]jsonElement, error) {
+    if err := p.expect(dec, json.Delim('[')); err != nil {
+        return nil, err
+    }
+    var elements []jsonElement
+    for dec.More() {
+        el := jsonElement{at: p.skip(int(dec.InputOffset()))}
+        if err := dec.Decode(&el.raw); err != nil {
+            return nil, p.syntaxError(err)
+        }
+        if len(el.raw) == 0 || el.raw[0] != '{' {
+            return nil, p.errorf(el.at, fmt.Sprintf("%s[%d]", field, len(elements)), "must be an object")
+        }
+        elements = append(elements, el)
+    }
+    if err := p.expect(dec, json.Delim(']')); err != nil {
+        return nil, err
+    }
+    return elements, nil
+}
+
+// unmarshal decodes a server or link, refusing fields it doesn't have
+func (p *jsonParser) unmarshal(el jsonElement, field string, v interface{}) error {
+    dec := json.NewDecoder(bytes.NewReader(el.raw))
+    dec.DisallowUnknownFields()
+    err := dec.Decode(v)
+    if err == nil {
+        return nil
+    }
+
+    if te, ok := err.(*json.UnmarshalTypeError); ok {
+        want := "a string"
+        if te.Type.Kind() != reflect.String {
+            want = "an integer"
+        }
+        return p.errorf(p.fieldAt(el, te.Field), field + "." + te.Field, "must be %s, not a %s", want, te.Value)
+    }
+    // The error for a field we don't know is only a message
+    const unknown = "json: unknown field "
+    if msg := err.Error(); strings.HasPrefix(msg, unknown) {
+        name, _ := strconv.Unquote(strings.TrimPrefix(msg, unknown))
+        return p.errorf(p.fieldAt(el, name), field + "." + name, "unknown field")
+    }
+    return p.syntaxError(err)
+}
+
+// skip returns the offset of the next value from the given offset, past
+// any whitespace and commas
+func (p *jsonParser) skip(offset int) int {
+    for offset < len(p.data) && strings.IndexByte(" \t\r\n,:", p.data[offset]) != -1 {
+        offset++
+    }
+    return offset
+}
+
+// fieldAt returns the offset of a field's name in a server or link, or
+// where it starts if the field isn't in it
+func (p *jsonParser) fieldAt(el jsonElement, name string) int {
+    if i := bytes.Index(el.raw, []byte(strconv.Quote(name))); i != -1 {
+        return el.at + i
+    }
+    return el.at
+}
+
+// position returns the line and column of an offset in the file
+func (p *jsonParser) position(offset int) (int, int) {
+    if offset > len(p.data) {
+        offset = len(p.data)
+    }
+    before := p.data[:offset]
+    line := bytes.Count(before, []byte("\n")) + 1
+    col := offset - bytes.LastIndexByte(before, '\n')
+    return line, col
+}
+
+// errorf returns an error saying where in the file it is, and the field it's
+// about
+func (p *jsonParser) errorf(offset int, field, format string, args ...interface{}) error {
+    line, col := p.position(offset)
+    msg := fmt.Sprintf(format, args...)
+    if field != "" {
+        msg = field + ": " + msg
+    }
+    return errors.Errorf("ParseTopologyFile: %s:%d:%d: %s", p.file, line, col, msg)
+}
+
+// syntaxError returns the error for a file that isn't valid JSON
+func (p *jsonParser) syntaxError(err error) error {
+    if se, ok := err.(*json.SyntaxError); ok {
+        return p.errorf(int(se.Offset) - 1, "", "%s", se.Error())
+    }
+    if err == io.EOF || err == io.ErrUnexpectedEOF {
+        return p.errorf(len(p.data), "", "unexpected end of file")
+    }
+    return p.errorf(0, "", "%s", err.Error())
+}
@@ -1,16 +1,28 @@
 package topology
 
+import "dvr/metric"
+
 // Topology setup for the network
 type Topology struct {
     NumServers int
     NumNeighbors int
+    // Infinity is the network's infinity metric, or 0 if the file
+    // doesn't set one
+    Infinity int
+    // Interval is the routing update interval in seconds, or 0 if the
+    // file doesn't set one
+    Interval int
     Servers map[int]*Server
 }
 
 // Server details
 type Server struct {
     ID uint16
+    // The server's name, if the file gives one
+    Name string
     Cost int
+    // The attributes of our link to the server, if the file gives any
+    Metrics metric.Link
     Bindy string
     IP string
     Port int
@@ -1,12 +1,27 @@
 package types
 
-import "time"
+import (
+    "dvr/message"
+    "dvr/metric"
+    "net"
+    "time"
+)
+
+// Packet is a packet the server received, along with the address it came from
+type Packet struct {
+    Data []byte
+    From *net.UDPAddr
+}
 
 // Server functionality ..
 type Server interface {
     // Update performs the application update comand
     Update(id1, id2 uint16, newCost int) error
 
+    // UpdateMetrics performs the application update command with link
+    // attributes instead of a cost
+    UpdateMetrics(id1, id2 uint16, m metric.Link) error
+
     // Step sends the routing update immediately, instead of waiting
     // for the update interval
     Step() error
@@ -18,23 +33,111 @@ type Server interface {
     // Display displays the current routing table.
     Display() error
 
+    // DisplayState displays every route in the routing table along with
+    // its state and timer.
+    DisplayState() error
+
     // Disable disables the link between this server and another
     Disable(id uint16) error
 
     // Crash simulates a server crashing
     Crash() error
+
+    // Reload parses the topology file again and applies what changed
+    Reload() error
 }
 
 // Router interface ..
 type Router interface {
     // Update sets the link cost between two neighbors to the given cost
     Update(id1, id2 uint16, newCost int) error
+    // UpdateMetrics sets some of the attributes of the link between two
+    // neighbors, and its cost to their composite cost
+    UpdateMetrics(id1, id2 uint16, m metric.Link) error
     // SendUpdates sends update packets to neighbors
     SendPacketUpdates() error
     // CheckUpdates checks for invalid links
     CheckUpdates(interval time.Duration) error 
     // DisplayTable displays the routing table
     DisplayTable()
+    // DisplayState displays the routing table with each route's state
+    DisplayState()
     // Disable disables the link between this server and another
     Disable(id uint16) error
+    // Stop stops the router from sending anything, when the server crashes
+    Stop()
+    // Reload parses the topology file again and applies what changed
+    Reload() error
+    // ChecksumFailures returns how many messages from each neighbor failed
+    // their checksum since it was last called, with 0 for unknown senders
+    ChecksumFailures() map[uint16]int
+    // Bind has the router send from the server's listener, so its packets
+    // come from the address its neighbors know it by
+    Bind(conn net.PacketConn)
+}
+
+// RoutingEngine works out the routes for a router. The router looks after
+// the transport, neighbor liveness and user commands, and tells the engine
+// what it hears. Engines aren't safe for concurrent use, the router makes
+// one call at a time.
+type RoutingEngine interface {
+    // HandleUpdate takes in an update message from a neighbor, and reports
+    // whether there's anything new to tell our neighbors
+    HandleUpdate(from uint16, msg *message.Message) bool
+    // SetLinkCost sets the cost of our direct link to a neighbor, a cost at
+    // or above infinity means the link is down
+    SetLinkCost(id uint16, cost int) bool
+    // Tick runs the engine's timers
+    Tick(now time.Time) bool
+    // Periodic is called once before each periodic update goes out
+    Periodic()
+    // Advertisement returns the update message to send to a neighbor. The
+    // router fills in the sender's and each entry's address.
+    Advertisement(to uint16) *message.Message
+    // Table returns the forwarding table, sorted by destination ID
+    Table() []Route
+    // Forget drops everything the engine knows about a destination that
+    // has left the network
+    Forget(id uint16)
+    // Wanted returns the destinations whose routes the next update needs
+    // to carry even though they haven't changed, and forgets them
+    Wanted() []uint16
 }
+
+// Route is an entry in a routing engine's forwarding table
+type Route struct {
+    // The destination, the server to hop to in order to reach it and the
+    // cost of getting there
+    ID uint16
+    NextHop uint16
+    Cost int
+
+    // Every next hop with the same cost, sorted by ID, for equal cost
+    // multipath. NextHop is the first of them.
+    NextHops []uint16
+
+    // The servers the route goes through, starting with the next hop, for
+    // engines that keep track of them
+    Path []uint16
+
+    // The sequence number the destination stamped the route with, for
+    // engines that use them
+    Seq uint32
+
+    // Where the route is in its lifecycle, one of the route states, and how
+    // long until that changes, for engines that keep route timers
+    State string
+    Timer time.Duration
+}
+
+// Route states, which every engine uses so that display means the same
+// thing whichever one is running
+const (
+    // StateValid routes are in use
+    StateValid = "valid"
+    // StateExpired routes are unreachable, but still known, until they're
+    // deleted
+    StateExpired = "expired"
+    // StateDeleted routes are gone
+    StateDeleted = "deleted"
+)